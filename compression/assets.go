@@ -0,0 +1,144 @@
+package compression
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AssetCache holds compressed copies of asset files in memory, so that each
+// file is only compressed once until it changes on disk. It is safe for
+// concurrent use: files are compressed without holding the lock, so that
+// compressing one file doesn't hold up requests for any other, and
+// concurrent requests for the same file wait for a single compression.
+type AssetCache struct {
+	mutex   sync.Mutex
+	entries map[string]*cachedAsset
+}
+
+// cachedAsset is the compressed content of one asset file, along with the
+// modification time and size of the file it was compressed from. encoded
+// and err must only be read once ready is closed.
+type cachedAsset struct {
+	modTime time.Time
+	size    int64
+	ready   chan struct{}
+	encoded map[string][]byte
+	err     error
+}
+
+// extensions maps content encodings to the file extension of precompressed
+// siblings, such as custom.css.gz
+var extensions = map[string]string{
+	constants.GzipEncoding:   ".gz",
+	constants.BrotliEncoding: ".br",
+}
+
+// Compressible returns true if files with the name's extension are worth
+// compressing
+func Compressible(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, compressible := range constants.CompressibleExtensions {
+		if ext == compressible {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the content of the file encoded with encoding, and the
+// modification time of the file. A precompressed sibling of the file is
+// used if there is one, otherwise the file is compressed and cached. ok is
+// false if there is no encoded content to serve, for example when the file
+// is a directory or too small to be worth compressing.
+func (cache *AssetCache) Get(conf *config.Config, file string, encoding string) (content []byte, modTime time.Time, ok bool, err error) {
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		cache.mutex.Lock()
+		delete(cache.entries, file)
+		cache.mutex.Unlock()
+		return nil, modTime, false, nil
+	}
+	modTime = info.ModTime()
+
+	sibling, err := ioutil.ReadFile(file + extensions[encoding])
+	if err == nil {
+		return sibling, modTime, true, nil
+	}
+
+	entry, compress := cache.entry(file, modTime, info.Size())
+	if compress {
+		raw, err := ioutil.ReadFile(file)
+		if err == nil {
+			entry.encoded, err = EncodeAll(conf, raw)
+		}
+		entry.err = err
+		close(entry.ready)
+		if err != nil {
+			cache.remove(file, entry)
+		}
+		cache.prune()
+	}
+	<-entry.ready
+
+	if entry.err != nil {
+		return nil, modTime, false, entry.err
+	}
+	content, ok = entry.encoded[encoding]
+	return content, modTime, ok, nil
+}
+
+// entry returns the cached entry for file. If there is no entry for the
+// file as it currently is on disk, a new one is added and compress is true,
+// in which case the caller has to compress the file and close ready.
+func (cache *AssetCache) entry(file string, modTime time.Time, size int64) (entry *cachedAsset, compress bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.entries == nil {
+		cache.entries = make(map[string]*cachedAsset)
+	}
+
+	entry, cached := cache.entries[file]
+	if cached && entry.modTime.Equal(modTime) && entry.size == size {
+		return entry, false
+	}
+	entry = &cachedAsset{modTime: modTime, size: size, ready: make(chan struct{})}
+	cache.entries[file] = entry
+	return entry, true
+}
+
+// remove drops the cached entry for file, unless it was already replaced
+func (cache *AssetCache) remove(file string, entry *cachedAsset) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.entries[file] == entry {
+		delete(cache.entries, file)
+	}
+}
+
+// prune drops the cached entries of files that no longer exist, so that
+// deleted assets don't stay in memory. The files are checked without
+// holding the lock.
+func (cache *AssetCache) prune() {
+	cache.mutex.Lock()
+	entries := make(map[string]*cachedAsset)
+	for file, entry := range cache.entries {
+		entries[file] = entry
+	}
+	cache.mutex.Unlock()
+
+	for file, entry := range entries {
+		_, err := os.Stat(file)
+		if os.IsNotExist(err) {
+			cache.remove(file, entry)
+		}
+	}
+}
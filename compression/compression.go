@@ -0,0 +1,147 @@
+package compression
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encodings returns the enabled content encodings, in order of preference
+func Encodings(conf *config.Config) []string {
+	encodings := []string{}
+	if conf.Compression.Brotli {
+		encodings = append(encodings, constants.BrotliEncoding)
+	}
+	if conf.Compression.Gzip {
+		encodings = append(encodings, constants.GzipEncoding)
+	}
+	return encodings
+}
+
+// brotliLevel is high, since content is only compressed once per render, but
+// below brotli.BestCompression, which is several times slower for little gain
+const brotliLevel = 9
+
+// Encode compresses content with the given content encoding, at a high
+// compression level since content is only compressed once per render
+func Encode(content []byte, encoding string) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	switch encoding {
+	case constants.GzipEncoding:
+		w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(content)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	case constants.BrotliEncoding:
+		w := brotli.NewWriterLevel(&buf, brotliLevel)
+		_, err := w.Write(content)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %v", encoding)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// EncodeAll compresses content with every enabled encoding. Content smaller
+// than the configured minimum size, or that doesn't get any smaller, is left
+// out, so the result may be empty.
+func EncodeAll(conf *config.Config, content []byte) (map[string][]byte, error) {
+	encoded := make(map[string][]byte)
+	if len(content) < conf.Compression.MinSize {
+		return encoded, nil
+	}
+
+	for _, encoding := range Encodings(conf) {
+		result, err := Encode(content, encoding)
+		if err != nil {
+			return encoded, fmt.Errorf("failed to encode content with %v: %v", encoding, err.Error())
+		}
+		if len(result) >= len(content) {
+			continue
+		}
+		encoded[encoding] = result
+	}
+
+	return encoded, nil
+}
+
+// Negotiate picks the content encoding to respond with from the request's
+// Accept-Encoding header. available is in order of preference, which is
+// used to break ties between encodings with the same quality value. An
+// empty string means the response should not be encoded.
+func Negotiate(acceptEncoding string, available []string) string {
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err == nil {
+				quality = q
+			}
+		}
+
+		if coding == "*" {
+			wildcard = quality
+			continue
+		}
+		qualities[coding] = quality
+	}
+
+	best := ""
+	bestQuality := 0.0
+	for _, encoding := range available {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best = encoding
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
+
+// VariantETag derives the entity tag of an encoded response from the entity
+// tag of the unencoded content, since the two must never be confused
+func VariantETag(etag string, encoding string) string {
+	if encoding == "" || etag == "" {
+		return etag
+	}
+	return fmt.Sprintf(`%v-%v"`, strings.TrimSuffix(etag, `"`), encoding)
+}
@@ -0,0 +1,171 @@
+package compression
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	assert := assert.New(t)
+
+	available := []string{constants.BrotliEncoding, constants.GzipEncoding}
+
+	tests := []struct {
+		TestName     string
+		InputHeader  string
+		InputEncs    []string
+		ExpectResult string
+	}{
+		{"Negotiate no header", "", available, ""},
+		{"Negotiate gzip only", "gzip", available, constants.GzipEncoding},
+		{"Negotiate preference order", "gzip, deflate, br", available, constants.BrotliEncoding},
+		{"Negotiate quality values", "br;q=0.5, gzip;q=0.8", available, constants.GzipEncoding},
+		{"Negotiate refused encoding", "br;q=0, gzip", available, constants.GzipEncoding},
+		{"Negotiate wildcard", "*", available, constants.BrotliEncoding},
+		{"Negotiate wildcard with exclusion", "*, br;q=0", available, constants.GzipEncoding},
+		{"Negotiate identity only", "identity", available, ""},
+		{"Negotiate case insensitive", "GZIP", available, constants.GzipEncoding},
+		{"Negotiate nothing available", "gzip, br", []string{}, ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(test.ExpectResult, Negotiate(test.InputHeader, test.InputEncs), test.TestName)
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	content := []byte(strings.Repeat("<p>light sites</p>\n", 100))
+
+	encoded, err := EncodeAll(&conf, content)
+	require.NoError(err)
+	require.Len(encoded, 2)
+
+	gz, err := gzip.NewReader(bytes.NewReader(encoded[constants.GzipEncoding]))
+	require.NoError(err)
+	decoded, err := ioutil.ReadAll(gz)
+	require.NoError(err)
+	assert.Equal(content, decoded)
+
+	decoded, err = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(encoded[constants.BrotliEncoding])))
+	require.NoError(err)
+	assert.Equal(content, decoded)
+
+	encoded, err = EncodeAll(&conf, []byte("<p>short</p>"))
+	require.NoError(err)
+	assert.Len(encoded, 0)
+
+	conf.Compression.Brotli = false
+	encoded, err = EncodeAll(&conf, content)
+	require.NoError(err)
+	assert.Len(encoded, 1)
+	assert.Contains(encoded, constants.GzipEncoding)
+}
+
+func TestVariantETag(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`"abc"`, VariantETag(`"abc"`, ""))
+	assert.Equal(`"abc-gzip"`, VariantETag(`"abc"`, constants.GzipEncoding))
+	assert.Equal(`"abc-br"`, VariantETag(`"abc"`, constants.BrotliEncoding))
+}
+
+func TestAssetCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "lightsites-assets")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	conf := config.GetDefaultConfig()
+	content := []byte(strings.Repeat("body { margin: 0; }\n", 100))
+	require.NoError(ioutil.WriteFile(dir+"/site.css", content, 0644))
+	require.NoError(ioutil.WriteFile(dir+"/site.css.br", []byte("precompressed"), 0644))
+
+	cache := &AssetCache{}
+
+	// a precompressed sibling is served as is
+	result, _, ok, err := cache.Get(&conf, dir+"/site.css", constants.BrotliEncoding)
+	require.NoError(err)
+	assert.True(ok)
+	assert.Equal("precompressed", string(result))
+
+	// otherwise the file is compressed and cached
+	result, _, ok, err = cache.Get(&conf, dir+"/site.css", constants.GzipEncoding)
+	require.NoError(err)
+	assert.True(ok)
+	expected, err := Encode(content, constants.GzipEncoding)
+	require.NoError(err)
+	assert.Equal(expected, result)
+
+	// a change to the file replaces the cached copy
+	changed := []byte(strings.Repeat("body { padding: 0; }\n", 100))
+	require.NoError(ioutil.WriteFile(dir+"/site.css", changed, 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(os.Chtimes(dir+"/site.css", later, later))
+	result, _, ok, err = cache.Get(&conf, dir+"/site.css", constants.GzipEncoding)
+	require.NoError(err)
+	assert.True(ok)
+	expected, err = Encode(changed, constants.GzipEncoding)
+	require.NoError(err)
+	assert.Equal(expected, result)
+
+	// missing files and directories have nothing to serve
+	_, _, ok, err = cache.Get(&conf, dir+"/missing.css", constants.GzipEncoding)
+	assert.NoError(err)
+	assert.False(ok)
+	_, _, ok, err = cache.Get(&conf, dir, constants.GzipEncoding)
+	assert.NoError(err)
+	assert.False(ok)
+
+	// concurrent requests for the same file all get its compressed content
+	require.NoError(ioutil.WriteFile(dir+"/other.css", content, 0644))
+	expected, err = Encode(content, constants.GzipEncoding)
+	require.NoError(err)
+	var wg sync.WaitGroup
+	results := make([][]byte, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _, _ = cache.Get(&conf, dir+"/other.css", constants.GzipEncoding)
+		}(i)
+	}
+	wg.Wait()
+	for _, result := range results {
+		assert.Equal(expected, result)
+	}
+	assert.Len(cache.entries, 2)
+
+	// deleted files are dropped from the cache once another file is
+	// compressed, or when they are requested again
+	require.NoError(os.Remove(dir + "/site.css"))
+	require.NoError(ioutil.WriteFile(dir+"/third.css", content, 0644))
+	_, _, ok, err = cache.Get(&conf, dir+"/third.css", constants.GzipEncoding)
+	require.NoError(err)
+	assert.True(ok)
+	assert.NotContains(cache.entries, dir+"/site.css")
+	require.NoError(os.Remove(dir + "/third.css"))
+	_, _, ok, err = cache.Get(&conf, dir+"/third.css", constants.GzipEncoding)
+	assert.NoError(err)
+	assert.False(ok)
+	assert.NotContains(cache.entries, dir+"/third.css")
+	assert.Len(cache.entries, 1)
+}
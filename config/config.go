@@ -5,6 +5,11 @@ import (
 
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -20,6 +25,8 @@ type RoutingConfig struct {
 	RoutePrefix   string `yaml:"routePrefix"`
 	AssetsPrefix  string `yaml:"assetsPrefix"`
 	UrlFileSuffix string `yaml:"urlFileSuffix"`
+	StatusPath    string `yaml:"statusPath"`
+	MetricsPath   string `yaml:"metricsPath"`
 }
 
 type BodyConfig struct {
@@ -33,14 +40,177 @@ type NodeRule struct {
 	Style string `yaml:"style"`
 }
 
+// WatchConfig controls polling of the documents and templates directories,
+// so that changes are rendered shortly after they are saved
+type WatchConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+// HealthConfig sets the paths of the liveness and readiness endpoints. They
+// are registered outside of the route prefix, so an empty path disables the
+// endpoint.
+type HealthConfig struct {
+	LivenessPath  string `yaml:"livenessPath"`
+	ReadinessPath string `yaml:"readinessPath"`
+}
+
+// RetryConfig controls how loading the site is retried after a failure,
+// such as the documents directory being temporarily unavailable
+type RetryConfig struct {
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+}
+
+// FailedDocumentsConfig controls what is served for documents that fail to
+// render
+type FailedDocumentsConfig struct {
+	// ServeStale keeps serving the last successful render of a document
+	// that fails to render
+	ServeStale bool `yaml:"serveStale"`
+	// Status is the HTTP status code used for failed documents that have no
+	// previous render to serve, usually 500 or 503
+	Status int `yaml:"status"`
+	// ErrorPage is an optional HTML file to serve for those documents
+	ErrorPage string `yaml:"errorPage"`
+}
+
+// CacheControlConfig sets the Cache-Control header of document and asset
+// responses. Routes maps request path prefixes to a header value, and the
+// longest matching prefix takes precedence over Default. An empty value
+// leaves the header unset.
+type CacheControlConfig struct {
+	Default string            `yaml:"default"`
+	Routes  map[string]string `yaml:"routes"`
+}
+
+// CompressionConfig controls the precompressed variants of documents, which
+// are generated once per render, and of assets, which are compressed on
+// first request unless a precompressed sibling such as custom.css.gz exists
+type CompressionConfig struct {
+	Gzip   bool `yaml:"gzip"`
+	Brotli bool `yaml:"brotli"`
+	// MinSize is the size in bytes below which content is not compressed
+	MinSize int `yaml:"minSize"`
+}
+
+// HeadersConfig sets extra response headers on documents and assets, such
+// as security headers. Routes maps request path prefixes to headers that
+// are merged over Default, with longer prefixes applied last. A header set
+// to an empty value is removed.
+type HeadersConfig struct {
+	Default map[string]string            `yaml:"default"`
+	Routes  map[string]map[string]string `yaml:"routes"`
+	// HSTS is the value of the Strict-Transport-Security header. It should
+	// only be set when the site is served over HTTPS.
+	HSTS string `yaml:"hsts"`
+	// OnionLocation is the address of a Tor onion service mirroring the
+	// site, such as http://example.onion. The request path is appended to it.
+	OnionLocation string `yaml:"onionLocation"`
+}
+
+// TemplatesConfig controls how template files are rendered
+type TemplatesConfig struct {
+	// Engine is the engine used for template files: legacy, which replaces
+	// {{variable}} with the tag's attributes, or html, which uses Go's
+	// html/template. Files ending in .gohtml always use html. It defaults to
+	// legacy.
+	Engine string `yaml:"engine"`
+	// MaxDepth is how deeply templates may include other templates. It
+	// defaults to 10.
+	MaxDepth int `yaml:"maxDepth"`
+}
+
+// LayoutsConfig selects the layout template that wraps the rendered
+// markdown of each document. Layouts are template files, relative to the
+// templates directory.
+type LayoutsConfig struct {
+	// Default is the layout of documents that don't select one. If it is
+	// empty, the body is wrapped in the grid from bodyConfig instead.
+	Default string `yaml:"default"`
+	// Directories maps directories of the documents directory, such as
+	// blog, to the layout of the documents in them. The longest matching
+	// directory takes precedence over Default.
+	Directories map[string]string `yaml:"directories"`
+}
+
+// SanitizeConfig controls how the no-JavaScript policy is enforced on
+// rendered documents
+type SanitizeConfig struct {
+	// Mode is strip (remove scripts, event handlers, javascript: URLs and
+	// iframes), warn (only report them), fail (fail the whole document) or
+	// off. It defaults to strip.
+	Mode string `yaml:"mode"`
+	// IframeAllowlist lists the hosts that iframes may embed
+	IframeAllowlist []string `yaml:"iframeAllowlist"`
+}
+
+// FeedConfig describes an RSS, Atom or JSON feed of the site's documents.
+// Hidden and draft documents are never included.
+type FeedConfig struct {
+	// Path is the route of the feed, relative to the route prefix, such as
+	// blog/index.xml
+	Path string `yaml:"path"`
+	// Format is rss, atom or json. It defaults to rss.
+	Format      string `yaml:"format"`
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	Author      string `yaml:"author"`
+	// Directory limits the feed to the documents in a directory, such as
+	// blog, and Tag limits it to the documents with a tag. The feed covers
+	// the whole site if neither is set.
+	Directory string `yaml:"directory"`
+	Tag       string `yaml:"tag"`
+	// Limit is the maximum number of documents in the feed, newest first,
+	// or 0 for all of them
+	Limit int `yaml:"limit"`
+}
+
+// SitemapConfig controls the sitemap, which lists every public document
+type SitemapConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// MaxURLs is the most documents listed in a single sitemap file. Larger
+	// sites are split into several files, listed by a sitemap index. It
+	// defaults to 50000, the most that a sitemap may hold.
+	MaxURLs int `yaml:"maxURLs"`
+}
+
+// RobotsConfig controls the robots.txt file that is served at the root
+type RobotsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// DisallowHidden asks crawlers not to visit hidden documents, without
+	// naming them
+	DisallowHidden bool `yaml:"disallowHidden"`
+	// Disallow lists additional paths that crawlers shouldn't visit
+	Disallow []string `yaml:"disallow"`
+}
+
 type Config struct {
 	RefreshInterval time.Duration                `yaml:"refreshInterval"`
+	Watch           WatchConfig                  `yaml:"watch"`
+	Retry           RetryConfig                  `yaml:"retry"`
+	Health          HealthConfig                 `yaml:"health"`
 	Directories     DirectoriesConfig            `yaml:"directories"`
 	Routing         RoutingConfig                `yaml:"routing"`
 	CSSImports      []string                     `yaml:"cssImports"`
 	BodyConfig      BodyConfig                   `yaml:"bodyConfig"`
 	Rules           map[string]map[string]string `yaml:"rules"`
 	ListenAddr      string                       `yaml:"listenAddr"`
+	FailedDocuments FailedDocumentsConfig        `yaml:"failedDocuments"`
+	CacheControl    CacheControlConfig           `yaml:"cacheControl"`
+	Compression     CompressionConfig            `yaml:"compression"`
+	Headers         HeadersConfig                `yaml:"headers"`
+	Sanitize        SanitizeConfig               `yaml:"sanitize"`
+	Templates       TemplatesConfig              `yaml:"templates"`
+	Layouts         LayoutsConfig                `yaml:"layouts"`
+	// BaseURL is the address the site is published at, such as
+	// https://example.com, which is needed for the absolute links in feeds
+	// and the sitemap
+	BaseURL string        `yaml:"baseURL"`
+	Feeds   []FeedConfig  `yaml:"feeds"`
+	Sitemap SitemapConfig `yaml:"sitemap"`
+	Robots  RobotsConfig  `yaml:"robots"`
 }
 
 // LoadConfig reads from a provided yaml-formatted configuration filename
@@ -59,11 +229,216 @@ func LoadConfig() (conf Config, err error) {
 	return conf, nil
 }
 
+// Validate checks that the endpoints served next to the documents (status,
+// metrics, liveness and readiness) don't collide with the document and asset
+// routes, that the onion location is an onion service address, that the
+// sanitize mode and template engine are known, and that feeds and the
+// sitemap are valid
+func (conf *Config) Validate() error {
+	err := conf.validateFeeds()
+	if err != nil {
+		return err
+	}
+
+	if conf.Sitemap.Enabled && conf.BaseURL == "" {
+		return fmt.Errorf("baseURL must be set to generate the sitemap")
+	}
+	if conf.Sitemap.MaxURLs < 0 || conf.Sitemap.MaxURLs > constants.SitemapMaxURLs {
+		return fmt.Errorf("sitemap.maxURLs %v must be between 0 and %v", conf.Sitemap.MaxURLs, constants.SitemapMaxURLs)
+	}
+
+	switch conf.Templates.Engine {
+	case "", constants.TemplateEngineLegacy, constants.TemplateEngineHTML:
+	default:
+		return fmt.Errorf("templates.engine %v must be either %v or %v", conf.Templates.Engine, constants.TemplateEngineLegacy, constants.TemplateEngineHTML)
+	}
+
+	switch conf.Sanitize.Mode {
+	case "", constants.SanitizeModeStrip, constants.SanitizeModeWarn, constants.SanitizeModeFail, constants.SanitizeModeOff:
+	default:
+		return fmt.Errorf("sanitize.mode %v must be one of %v, %v, %v or %v", conf.Sanitize.Mode, constants.SanitizeModeStrip, constants.SanitizeModeWarn, constants.SanitizeModeFail, constants.SanitizeModeOff)
+	}
+
+	if conf.Headers.OnionLocation != "" {
+		onion, err := url.Parse(conf.Headers.OnionLocation)
+		if err != nil || (onion.Scheme != "http" && onion.Scheme != "https") || !strings.HasSuffix(onion.Hostname(), ".onion") {
+			return fmt.Errorf("headers.onionLocation %v must be an http or https address ending in .onion", conf.Headers.OnionLocation)
+		}
+	}
+
+	endpoints := map[string]string{
+		"routing.statusPath":   conf.Routing.StatusPath,
+		"routing.metricsPath":  conf.Routing.MetricsPath,
+		"health.livenessPath":  conf.Health.LivenessPath,
+		"health.readinessPath": conf.Health.ReadinessPath,
+	}
+
+	seen := make(map[string]string)
+	keys := []string{"routing.statusPath", "routing.metricsPath", "health.livenessPath", "health.readinessPath"}
+	for _, key := range keys {
+		endpoint := endpoints[key]
+		if endpoint == "" {
+			continue
+		}
+		if !strings.HasPrefix(endpoint, "/") {
+			return fmt.Errorf("%v %v must start with /", key, endpoint)
+		}
+		other, ok := seen[endpoint]
+		if ok {
+			return fmt.Errorf("%v %v is already used by %v", key, endpoint, other)
+		}
+		seen[endpoint] = key
+
+		if endpoint == conf.Routing.RoutePrefix || strings.HasPrefix(endpoint, conf.Routing.AssetsPrefix) {
+			return fmt.Errorf("%v %v collides with the document or asset routes", key, endpoint)
+		}
+		if strings.HasPrefix(endpoint, conf.Routing.RoutePrefix) && strings.HasSuffix(endpoint, conf.Routing.UrlFileSuffix) {
+			return fmt.Errorf("%v %v would shadow a document, since it ends with %v", key, endpoint, conf.Routing.UrlFileSuffix)
+		}
+	}
+
+	return nil
+}
+
+// validateFeeds checks that every feed has a known format and a unique path
+// that doesn't shadow a document, and that the base URL is set if there are
+// any feeds
+func (conf *Config) validateFeeds() error {
+	if conf.BaseURL != "" {
+		base, err := url.Parse(conf.BaseURL)
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			return fmt.Errorf("baseURL %v must be an absolute http or https address", conf.BaseURL)
+		}
+	}
+	if len(conf.Feeds) > 0 && conf.BaseURL == "" {
+		return fmt.Errorf("baseURL must be set to generate feeds")
+	}
+
+	seen := make(map[string]bool)
+	for _, feed := range conf.Feeds {
+		switch feed.Format {
+		case "", constants.FeedFormatRSS, constants.FeedFormatAtom, constants.FeedFormatJSON:
+		default:
+			return fmt.Errorf("feed %v has format %v, which must be one of %v, %v or %v", feed.Path, feed.Format, constants.FeedFormatRSS, constants.FeedFormatAtom, constants.FeedFormatJSON)
+		}
+		feedPath := strings.Trim(feed.Path, "/")
+		if feedPath == "" {
+			return fmt.Errorf("feeds must have a path")
+		}
+		if strings.HasSuffix(feedPath, conf.Routing.UrlFileSuffix) {
+			return fmt.Errorf("feed %v would shadow a document, since it ends with %v", feed.Path, conf.Routing.UrlFileSuffix)
+		}
+		if conf.Sitemap.Enabled && isSitemapFile(feedPath) {
+			return fmt.Errorf("feed %v is already used by the sitemap", feed.Path)
+		}
+		if seen[feedPath] {
+			return fmt.Errorf("feed %v is configured more than once", feed.Path)
+		}
+		seen[feedPath] = true
+		if feed.Limit < 0 {
+			return fmt.Errorf("feed %v has limit %v, which must not be negative", feed.Path, feed.Limit)
+		}
+	}
+
+	return nil
+}
+
+// isSitemapFile returns true if a path relative to the route prefix is one
+// of the sitemap's files
+func isSitemapFile(filePath string) bool {
+	if filePath == constants.SitemapFile {
+		return true
+	}
+	prefix := strings.Split(constants.SitemapPartFile, "%v")[0]
+	suffix := strings.Split(constants.SitemapPartFile, "%v")[1]
+	if !strings.HasPrefix(filePath, prefix) || !strings.HasSuffix(filePath, suffix) {
+		return false
+	}
+	_, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filePath, prefix), suffix))
+	return err == nil
+}
+
+// GetCacheControl returns the Cache-Control header value for a request path
+func (conf *Config) GetCacheControl(requestPath string) string {
+	value := conf.CacheControl.Default
+	longest := -1
+	for prefix, routeValue := range conf.CacheControl.Routes {
+		if strings.HasPrefix(requestPath, prefix) && len(prefix) > longest {
+			value = routeValue
+			longest = len(prefix)
+		}
+	}
+	return value
+}
+
+// GetLayout returns the configured layout for a document, given its file
+// name relative to the documents directory without the .md extension. An
+// empty layout means the document isn't wrapped in a layout template.
+func (conf *Config) GetLayout(fileName string) string {
+	layout := conf.Layouts.Default
+	longest := -1
+	for directory, directoryLayout := range conf.Layouts.Directories {
+		prefix := strings.Trim(directory, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		if strings.HasPrefix(fileName, prefix) && len(prefix) > longest {
+			layout = directoryLayout
+			longest = len(prefix)
+		}
+	}
+	return layout
+}
+
+// GetHeaders returns the extra response headers for a request path,
+// including headers with empty values, which should be removed
+func (conf *Config) GetHeaders(requestPath string) map[string]string {
+	headers := make(map[string]string)
+	for key, value := range conf.Headers.Default {
+		headers[http.CanonicalHeaderKey(key)] = value
+	}
+
+	prefixes := []string{}
+	for prefix := range conf.Headers.Routes {
+		if strings.HasPrefix(requestPath, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+	for _, prefix := range prefixes {
+		for key, value := range conf.Headers.Routes[prefix] {
+			headers[http.CanonicalHeaderKey(key)] = value
+		}
+	}
+
+	if conf.Headers.HSTS != "" {
+		headers[constants.HSTSHeader] = conf.Headers.HSTS
+	}
+	if conf.Headers.OnionLocation != "" {
+		headers[constants.OnionLocationHeader] = strings.TrimSuffix(conf.Headers.OnionLocation, "/") + requestPath
+	}
+
+	return headers
+}
+
 // GetDefaultConfig returns a basic sample configuration and
 // is mainly used for unit testing
 func GetDefaultConfig() Config {
 	return Config{
 		RefreshInterval: time.Duration(30 * time.Minute),
+		Watch: WatchConfig{
+			Enabled:  false,
+			Interval: time.Duration(2 * time.Second),
+			Debounce: time.Duration(500 * time.Millisecond),
+		},
+		Retry: RetryConfig{
+			InitialBackoff: constants.DefaultInitialBackoff,
+			MaxBackoff:     constants.DefaultMaxBackoff,
+		},
+		Health: HealthConfig{
+			LivenessPath:  "/healthz",
+			ReadinessPath: "/readyz",
+		},
 		Directories: DirectoriesConfig{
 			Assets:    "./src/assets",
 			Documents: "./src/content",
@@ -73,6 +448,8 @@ func GetDefaultConfig() Config {
 			RoutePrefix:   "/content/",
 			AssetsPrefix:  "/assets/",
 			UrlFileSuffix: ".html",
+			StatusPath:    "/status",
+			MetricsPath:   "/metrics",
 		},
 		CSSImports: []string{
 			"bootstrap.min.css",
@@ -92,5 +469,35 @@ func GetDefaultConfig() Config {
 			},
 		},
 		ListenAddr: ":8099",
+		FailedDocuments: FailedDocumentsConfig{
+			ServeStale: true,
+			Status:     constants.DefaultFailedDocumentStatus,
+		},
+		CacheControl: CacheControlConfig{
+			Default: "no-cache",
+			Routes: map[string]string{
+				"/assets/": "public, max-age=3600",
+			},
+		},
+		Compression: CompressionConfig{
+			Gzip:    true,
+			Brotli:  true,
+			MinSize: 256,
+		},
+		Templates: TemplatesConfig{
+			Engine:   constants.TemplateEngineLegacy,
+			MaxDepth: constants.DefaultTemplateMaxDepth,
+		},
+		Sanitize: SanitizeConfig{
+			Mode: constants.SanitizeModeStrip,
+		},
+		Headers: HeadersConfig{
+			Default: map[string]string{
+				constants.CSPHeader:                constants.DefaultCSP,
+				constants.ReferrerPolicyHeader:     constants.DefaultReferrerPolicy,
+				constants.ContentTypeOptionsHeader: "nosniff",
+				constants.PermissionsPolicyHeader:  constants.DefaultPermissionsPolicy,
+			},
+		},
 	}
 }
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"lightsites/constants"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName    string
+		Modify      func(conf *Config)
+		ExpectError bool
+	}{
+		{"Validate default config", func(conf *Config) {}, false},
+		{"Validate disabled endpoints", func(conf *Config) {
+			conf.Routing.StatusPath = ""
+			conf.Routing.MetricsPath = ""
+			conf.Health.LivenessPath = ""
+			conf.Health.ReadinessPath = ""
+		}, false},
+		{"Validate endpoints under a root route prefix", func(conf *Config) { conf.Routing.RoutePrefix = "/" }, false},
+		{"Validate relative endpoint", func(conf *Config) { conf.Health.LivenessPath = "healthz" }, true},
+		{"Validate duplicate endpoints", func(conf *Config) { conf.Health.ReadinessPath = conf.Health.LivenessPath }, true},
+		{"Validate metrics endpoint duplicating status", func(conf *Config) { conf.Routing.MetricsPath = conf.Routing.StatusPath }, true},
+		{"Validate endpoint equal to the route prefix", func(conf *Config) { conf.Health.LivenessPath = conf.Routing.RoutePrefix }, true},
+		{"Validate endpoint under the assets prefix", func(conf *Config) { conf.Health.LivenessPath = "/assets/healthz" }, true},
+		{"Validate endpoint shadowing a document", func(conf *Config) { conf.Health.ReadinessPath = "/content/readyz.html" }, true},
+		{"Validate html template engine", func(conf *Config) { conf.Templates.Engine = constants.TemplateEngineHTML }, false},
+		{"Validate unknown template engine", func(conf *Config) { conf.Templates.Engine = "mustache" }, true},
+		{"Validate sanitize mode", func(conf *Config) { conf.Sanitize.Mode = constants.SanitizeModeFail }, false},
+		{"Validate unknown sanitize mode", func(conf *Config) { conf.Sanitize.Mode = "remove" }, true},
+		{"Validate onion location", func(conf *Config) { conf.Headers.OnionLocation = "http://example.onion" }, false},
+		{"Validate onion location without onion host", func(conf *Config) { conf.Headers.OnionLocation = "https://example.com" }, true},
+		{"Validate onion location without scheme", func(conf *Config) { conf.Headers.OnionLocation = "example.onion" }, true},
+		{"Validate feeds", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Feeds = []FeedConfig{{Path: "blog/index.xml", Directory: "blog"}, {Path: "feed.json", Format: constants.FeedFormatJSON}}
+		}, false},
+		{"Validate feeds without base URL", func(conf *Config) { conf.Feeds = []FeedConfig{{Path: "index.xml"}} }, true},
+		{"Validate relative base URL", func(conf *Config) { conf.BaseURL = "example.com" }, true},
+		{"Validate feed without path", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Feeds = []FeedConfig{{Path: "/"}}
+		}, true},
+		{"Validate unknown feed format", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Feeds = []FeedConfig{{Path: "index.xml", Format: "rdf"}}
+		}, true},
+		{"Validate duplicate feed paths", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Feeds = []FeedConfig{{Path: "index.xml"}, {Path: "/index.xml", Format: constants.FeedFormatAtom}}
+		}, true},
+		{"Validate sitemap", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Sitemap = SitemapConfig{Enabled: true, MaxURLs: 1000}
+		}, false},
+		{"Validate sitemap without base URL", func(conf *Config) { conf.Sitemap.Enabled = true }, true},
+		{"Validate sitemap with too many URLs", func(conf *Config) { conf.Sitemap.MaxURLs = 50001 }, true},
+		{"Validate feed shadowing the sitemap", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Sitemap.Enabled = true
+			conf.Feeds = []FeedConfig{{Path: "sitemap-2.xml"}}
+		}, true},
+		{"Validate feed shadowing a document", func(conf *Config) {
+			conf.BaseURL = "https://example.com"
+			conf.Feeds = []FeedConfig{{Path: "feed.html"}}
+		}, true},
+	}
+
+	for _, test := range tests {
+		conf := GetDefaultConfig()
+		test.Modify(&conf)
+		err := conf.Validate()
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+		} else {
+			assert.NoError(err, test.TestName)
+		}
+	}
+}
+
+func TestGetCacheControl(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := GetDefaultConfig()
+	conf.CacheControl = CacheControlConfig{
+		Default: "no-cache",
+		Routes: map[string]string{
+			"/assets/":       "public, max-age=3600",
+			"/assets/fonts/": "public, max-age=31536000, immutable",
+		},
+	}
+
+	tests := []struct {
+		TestName    string
+		InputPath   string
+		ExpectValue string
+	}{
+		{"GetCacheControl default", "/content/index.html", "no-cache"},
+		{"GetCacheControl route", "/assets/custom.css", "public, max-age=3600"},
+		{"GetCacheControl longest prefix", "/assets/fonts/font.woff2", "public, max-age=31536000, immutable"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(test.ExpectValue, conf.GetCacheControl(test.InputPath), test.TestName)
+	}
+}
+
+func TestGetHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := GetDefaultConfig()
+	conf.Headers.Routes = map[string]map[string]string{
+		"/assets/": {
+			"cross-origin-resource-policy": "same-origin",
+			"Referrer-Policy":              "same-origin",
+		},
+		"/assets/fonts/": {
+			"Cross-Origin-Resource-Policy": "cross-origin",
+			"Content-Security-Policy":      "",
+		},
+	}
+	conf.Headers.HSTS = "max-age=63072000"
+	conf.Headers.OnionLocation = "http://example.onion/"
+
+	headers := conf.GetHeaders("/content/index.html")
+	assert.Equal(constants.DefaultCSP, headers["Content-Security-Policy"])
+	assert.Equal("no-referrer", headers["Referrer-Policy"])
+	assert.Equal("nosniff", headers["X-Content-Type-Options"])
+	assert.Equal("max-age=63072000", headers["Strict-Transport-Security"])
+	assert.Equal("http://example.onion/content/index.html", headers["Onion-Location"])
+	assert.NotContains(headers, "Cross-Origin-Resource-Policy")
+
+	headers = conf.GetHeaders("/assets/custom.css")
+	assert.Equal("same-origin", headers["Cross-Origin-Resource-Policy"])
+	assert.Equal("same-origin", headers["Referrer-Policy"])
+
+	headers = conf.GetHeaders("/assets/fonts/font.woff2")
+	assert.Equal("cross-origin", headers["Cross-Origin-Resource-Policy"])
+	assert.Equal("same-origin", headers["Referrer-Policy"])
+	assert.Equal("", headers["Content-Security-Policy"])
+}
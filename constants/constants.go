@@ -1,20 +1,192 @@
 package constants
 
+import "time"
+
 const (
 	ConfigFile = "config.yml"
 
 	// AllowedHeaders = "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, X-CSRF-Token"
 	AssetsPrefixURL       = "/assets/" // don't forget the trailing slash
+	AliasesAttribute      = "aliases"
 	AttributeTag          = "attributes"
 	ContentPrefixURL      = "/content/" // don't forget the trailing slash
 	DistDirectory         = RootDataDirectory + "/content"
+	IndexDocument         = "index"
 	AssetsDirectory       = RootDataDirectory + "/assets"
 	TemplatesDirectory    = RootDataDirectory + "/templates"
 	RootDataDirectory     = "./src"
 	TemplateFileKey       = "file"
 	TemplateHeadingKey    = "heading"
+	TemplateRegionKey     = "region"
 	TitleAttribute        = "title"
 	TitleAttributeExample = "Your Document Title"
+	DescriptionAttribute  = "description"
+	DateAttribute         = "date"
+	TagsAttribute         = "tags"
+	DraftAttribute        = "draft"
+	SlugAttribute         = "slug"
+	WeightAttribute       = "weight"
+
+	// front matter delimiters, which must be on the first line of a document
+	YAMLFrontMatterDelimiter = "---"
+	TOMLFrontMatterDelimiter = "+++"
+
+	// command line subcommands
+	BuildCommand          = "build"
+	DepsCommand           = "deps"
+	CheckCommand          = "check"
+	TemplatesCommand      = "templates"
+	DefaultBuildDirectory = "dist"
+
+	// response header containing the generation of the site snapshot
+	// that served the request
+	GenerationHeader = "X-Site-Generation"
+	// response header that is set when a document failed to render and its
+	// previous render is served instead
+	StaleHeader = "X-Document-Stale"
+
+	// health states of the loaded site
+	HealthStateLoading  = "loading"
+	HealthStateOK       = "ok"
+	HealthStateDegraded = "degraded"
+
+	// security headers, and the defaults that are sent with every document
+	// and asset. The content security policy forbids scripts entirely.
+	// Inline styles are allowed because rules can add style attributes.
+	CSPHeader                = "Content-Security-Policy"
+	ReferrerPolicyHeader     = "Referrer-Policy"
+	ContentTypeOptionsHeader = "X-Content-Type-Options"
+	PermissionsPolicyHeader  = "Permissions-Policy"
+	HSTSHeader               = "Strict-Transport-Security"
+	OnionLocationHeader      = "Onion-Location"
+	DefaultCSP               = "default-src 'self'; script-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'self'"
+	DefaultReferrerPolicy    = "no-referrer"
+	DefaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=(), interest-cohort=()"
+
+	// template engines. Template files with the HTML template extension
+	// always use the html/template engine.
+	TemplateEngineLegacy      = "legacy"
+	TemplateEngineHTML        = "html"
+	HTMLTemplateFileExtension = ".gohtml"
+
+	// slots of a template: {{content}} is the inner HTML of the <template>
+	// tag, and {{slot:name}} is the inner HTML of its <slot name="name">
+	// children
+	DefaultSlot        = "content"
+	SlotVariablePrefix = "slot:"
+	SlotNameKey        = "name"
+
+	// parameters declared in the front matter of a template file, and the
+	// options of each parameter
+	TemplateParamsKey           = "params"
+	TemplateParamNameKey        = "name"
+	TemplateParamDescriptionKey = "description"
+	TemplateParamTypeKey        = "type"
+	TemplateParamRequiredKey    = "required"
+	TemplateParamDefaultKey     = "default"
+	TemplateParamAllowedKey     = "allowed"
+
+	// types of template parameters
+	TemplateParamTypeString = "string"
+	TemplateParamTypeBool   = "bool"
+	TemplateParamTypeInt    = "int"
+	TemplateParamTypeURL    = "url"
+
+	// layouts wrap the rendered markdown of a document. The layout
+	// attribute selects a document's layout, and "none" opts out of the
+	// configured one. Regions are filled by the document and used by the
+	// layout as slots, with the rendered markdown in the main region.
+	LayoutAttribute = "layout"
+	LayoutNone      = "none"
+	RegionHeader    = "header"
+	RegionMain      = "main"
+	RegionSidebar   = "sidebar"
+	RegionFooter    = "footer"
+
+	// attributes of the <directory> tag, and their values
+	DirectoryPathKey        = "path"
+	DirectoryDepthKey       = "depth"
+	DirectorySortKey        = "sort"
+	DirectoryOrderKey       = "order"
+	DirectoryLimitKey       = "limit"
+	DirectoryExcludeKey     = "exclude"
+	DirectoryTreeKey        = "tree"
+	DirectoryDescriptionKey = "description"
+	DirectoryDateKey        = "date"
+	DirectorySortName       = "name"
+	DirectorySortTitle      = "title"
+	DirectorySortDate       = "date"
+	DirectorySortWeight     = "weight"
+	DirectoryOrderAsc       = "asc"
+	DirectoryOrderDesc      = "desc"
+	DirectoryDateFormat     = "2006-01-02"
+
+	// feed formats, and the content types they are served with
+	FeedFormatRSS       = "rss"
+	FeedFormatAtom      = "atom"
+	FeedFormatJSON      = "json"
+	RSSContentType      = "application/rss+xml"
+	AtomContentType     = "application/atom+xml"
+	JSONFeedContentType = "application/feed+json"
+
+	// the sitemap is served at the route prefix, and split into numbered
+	// parts listed by a sitemap index when it has more URLs than a single
+	// sitemap may hold. robots.txt is always served at the root.
+	SitemapFile        = "sitemap.xml"
+	SitemapPartFile    = "sitemap-%v.xml"
+	SitemapMaxURLs     = 50000
+	SitemapContentType = "application/xml"
+	RobotsPath         = "/robots.txt"
+	RobotsContentType  = "text/plain; charset=utf-8"
+
+	// document attributes that control how a document is listed in the
+	// sitemap
+	SitemapAttribute    = "sitemap"
+	PriorityAttribute   = "priority"
+	ChangeFreqAttribute = "changefreq"
+
+	// how many characters of a document's first paragraph are used as its
+	// summary
+	SummaryLength = 200
+
+	// how deeply templates may include other templates, if not configured
+	DefaultTemplateMaxDepth = 10
+
+	// sanitization modes for the no-JavaScript policy
+	SanitizeModeStrip = "strip"
+	SanitizeModeWarn  = "warn"
+	SanitizeModeFail  = "fail"
+	SanitizeModeOff   = "off"
+
+	// rules of the no-JavaScript policy, as reported in violations
+	ViolationScript        = "script"
+	ViolationEventHandler  = "event-handler"
+	ViolationJavaScriptURL = "javascript-url"
+	ViolationIframe        = "iframe"
+
+	// content encodings that documents and assets can be compressed with
+	GzipEncoding   = "gzip"
+	BrotliEncoding = "br"
+
+	// kinds of site refresh, as reported by the refresh duration metric
+	RefreshKindFull        = "full"
+	RefreshKindIncremental = "incremental"
+
+	// route labels of the request metrics, one per registered handler
+	RouteLabelContent   = "content"
+	RouteLabelAssets    = "assets"
+	RouteLabelStatus    = "status"
+	RouteLabelMetrics   = "metrics"
+	RouteLabelLiveness  = "liveness"
+	RouteLabelReadiness = "readiness"
+	RouteLabelRobots    = "robots"
+
+	// default backoff between attempts to load the site after a failure
+	DefaultInitialBackoff = 1 * time.Second
+	DefaultMaxBackoff     = 1 * time.Minute
+
+	// status code for documents that failed to render, if not configured
+	DefaultFailedDocumentStatus = 503
 
 	URLFileSuffix      = ".html"
 	MarkdownFileSuffix = ".md"
@@ -29,6 +201,12 @@ const (
 	TitleNode     = "title"
 	LinkNode      = "link"
 	DivNode       = "div"
+	ScriptNode    = "script"
+	IframeNode    = "iframe"
+	MetaNode      = "meta"
+	SlotNode      = "slot"
+	RegionNode    = "region"
+	ParagraphNode = "p"
 
 	// commonly used HTML attributes
 	StyleAttribute       = "style"
@@ -36,6 +214,7 @@ const (
 	SrcAttribute         = "src"
 	HrefAttribute        = "href"
 	RelAttribute         = "rel"
+	TargetAttribute      = "target"
 	RelValue             = "noopener noreferrer"
 	StylesheetVal        = "stylesheet"
 	CrossOriginAttribute = "crossorigin"
@@ -52,3 +231,52 @@ const (
 	RowClass                = "row"
 	ColClass                = "col-lg-12"
 )
+
+// Commands lists every command line subcommand
+var Commands = []string{
+	BuildCommand,
+	DepsCommand,
+	CheckCommand,
+	TemplatesCommand,
+}
+
+// TemplateParamTypes lists every type a template parameter can declare
+var TemplateParamTypes = []string{
+	TemplateParamTypeString,
+	TemplateParamTypeBool,
+	TemplateParamTypeInt,
+	TemplateParamTypeURL,
+}
+
+// LayoutRegions lists the regions of a layout, in the order they are placed
+// around the grid when a document has no layout
+var LayoutRegions = []string{
+	RegionHeader,
+	RegionMain,
+	RegionSidebar,
+	RegionFooter,
+}
+
+// SitemapChangeFreqs lists the values of a document's changefreq attribute
+var SitemapChangeFreqs = []string{
+	"always",
+	"hourly",
+	"daily",
+	"weekly",
+	"monthly",
+	"yearly",
+	"never",
+}
+
+// CompressibleExtensions lists the extensions of asset files that are served
+// compressed. Images and fonts are usually compressed already.
+var CompressibleExtensions = []string{
+	".css",
+	".js",
+	".svg",
+	".html",
+	".txt",
+	".xml",
+	".json",
+	".map",
+}
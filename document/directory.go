@@ -0,0 +1,314 @@
+package document
+
+import (
+	"lightsites/constants"
+
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// directoryOptions holds the attributes of a <directory> tag
+type directoryOptions struct {
+	// Path limits the listing to the documents in a directory, such as blog
+	Path string
+	// Depth is how many levels of directories below Path are listed, or 0
+	// for all of them
+	Depth int
+	// Sort is name, title, date or weight, and Order is asc or desc
+	Sort  string
+	Order string
+	// Limit is the maximum number of documents listed, or 0 for all of them
+	Limit int
+	// Exclude lists path.Match patterns of document names to leave out
+	Exclude []string
+	// Tree nests the listing in a <ul> per directory
+	Tree bool
+	// Description and Date add the description and date of each document
+	Description bool
+	Date        bool
+}
+
+// directoryItem is a document or directory in a nested listing
+type directoryItem struct {
+	entry *IndexEntry
+	dir   *directoryTree
+}
+
+// directoryTree is a directory in a nested listing, holding its documents
+// and subdirectories in the order they are listed
+type directoryTree struct {
+	name  string
+	items []directoryItem
+}
+
+// parseDirectoryOptions reads the attributes of a <directory> tag
+func parseDirectoryOptions(n *html.Node) (opts directoryOptions, err error) {
+	opts.Sort = constants.DirectorySortName
+	opts.Order = constants.DirectoryOrderAsc
+
+	for _, attr := range n.Attr {
+		val := strings.TrimSpace(attr.Val)
+		switch attr.Key {
+		case constants.DirectoryPathKey:
+			opts.Path = strings.Trim(val, "/")
+		case constants.DirectoryDepthKey, constants.DirectoryLimitKey:
+			number, err := strconv.Atoi(val)
+			if err != nil || number < 0 {
+				return opts, fmt.Errorf("directory attribute %v %v must be a non-negative integer", attr.Key, val)
+			}
+			if attr.Key == constants.DirectoryDepthKey {
+				opts.Depth = number
+			} else {
+				opts.Limit = number
+			}
+		case constants.DirectorySortKey:
+			switch val {
+			case constants.DirectorySortName, constants.DirectorySortTitle, constants.DirectorySortDate, constants.DirectorySortWeight:
+				opts.Sort = val
+			default:
+				return opts, fmt.Errorf("directory attribute %v %v must be one of %v, %v, %v or %v", attr.Key, val, constants.DirectorySortName, constants.DirectorySortTitle, constants.DirectorySortDate, constants.DirectorySortWeight)
+			}
+		case constants.DirectoryOrderKey:
+			switch val {
+			case constants.DirectoryOrderAsc, constants.DirectoryOrderDesc:
+				opts.Order = val
+			default:
+				return opts, fmt.Errorf("directory attribute %v %v must be either %v or %v", attr.Key, val, constants.DirectoryOrderAsc, constants.DirectoryOrderDesc)
+			}
+		case constants.DirectoryExcludeKey:
+			for _, pattern := range strings.Split(val, ",") {
+				pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+				if pattern == "" {
+					continue
+				}
+				_, err := path.Match(pattern, "")
+				if err != nil {
+					return opts, fmt.Errorf("directory attribute %v has invalid pattern %v: %v", attr.Key, pattern, err.Error())
+				}
+				opts.Exclude = append(opts.Exclude, pattern)
+			}
+		case constants.DirectoryTreeKey, constants.DirectoryDescriptionKey, constants.DirectoryDateKey:
+			enabled, err := strconv.ParseBool(val)
+			if err != nil {
+				return opts, fmt.Errorf("directory attribute %v %v must be true or false", attr.Key, val)
+			}
+			switch attr.Key {
+			case constants.DirectoryTreeKey:
+				opts.Tree = enabled
+			case constants.DirectoryDescriptionKey:
+				opts.Description = enabled
+			case constants.DirectoryDateKey:
+				opts.Date = enabled
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// IsHidden returns true if the document, or any directory it is in, starts
+// with a "."
+func IsHidden(fileName string) bool {
+	for _, element := range strings.Split(fileName, "/") {
+		if strings.HasPrefix(element, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeName returns the name of a document relative to the listed
+// directory, or false if the document isn't in it
+func (opts directoryOptions) relativeName(fileName string) (string, bool) {
+	if opts.Path == "" {
+		return fileName, true
+	}
+	if !strings.HasPrefix(fileName, opts.Path+"/") {
+		return "", false
+	}
+	return strings.TrimPrefix(fileName, opts.Path+"/"), true
+}
+
+// excludes returns true if the document matches one of the exclude patterns
+func (opts directoryOptions) excludes(fileName string) bool {
+	for _, pattern := range opts.Exclude {
+		matched, _ := path.Match(pattern, fileName)
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// less orders two documents by the sort option. Documents that compare
+// equal keep the order of the directory listing, which is by name.
+func (opts directoryOptions) less(a IndexEntry, b IndexEntry) bool {
+	if opts.Order == constants.DirectoryOrderDesc {
+		a, b = b, a
+	}
+	switch opts.Sort {
+	case constants.DirectorySortTitle:
+		return strings.ToLower(entryTitle(a, a.FileName)) < strings.ToLower(entryTitle(b, b.FileName))
+	case constants.DirectorySortDate:
+		return a.Date.Before(b.Date)
+	case constants.DirectorySortWeight:
+		return a.Weight < b.Weight
+	}
+	return a.FileName < b.FileName
+}
+
+// entryTitle returns the title of a document, or fallback if it has none
+func entryTitle(entry IndexEntry, fallback string) string {
+	if entry.Title != "" {
+		return entry.Title
+	}
+	return fallback
+}
+
+// listDocuments returns the documents a <directory> tag lists, in order.
+// Hidden and draft documents are never listed.
+func (document *Document) listDocuments(opts directoryOptions) []IndexEntry {
+	entries := []IndexEntry{}
+	for _, file := range *document.DocumentDirectory {
+		if IsHidden(file) || opts.excludes(file) {
+			continue
+		}
+		rel, ok := opts.relativeName(file)
+		if !ok {
+			continue
+		}
+		if opts.Depth > 0 && strings.Count(rel, "/") >= opts.Depth {
+			continue
+		}
+		entry := document.Index.Lookup(file)
+		if entry.Draft {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return opts.less(entries[i], entries[j])
+	})
+
+	if opts.Limit > 0 && len(entries) > opts.Limit {
+		entries = entries[:opts.Limit]
+	}
+
+	return entries
+}
+
+// buildDirectoryTree nests the listed documents by directory. Each
+// directory is listed where its first document would be.
+func buildDirectoryTree(opts directoryOptions, entries []IndexEntry) *directoryTree {
+	root := &directoryTree{}
+	for i := range entries {
+		rel, _ := opts.relativeName(entries[i].FileName)
+		elements := strings.Split(rel, "/")
+
+		current := root
+		for _, element := range elements[:len(elements)-1] {
+			var next *directoryTree
+			for _, item := range current.items {
+				if item.dir != nil && item.dir.name == element {
+					next = item.dir
+					break
+				}
+			}
+			if next == nil {
+				next = &directoryTree{name: element}
+				current.items = append(current.items, directoryItem{dir: next})
+			}
+			current = next
+		}
+		current.items = append(current.items, directoryItem{entry: &entries[i]})
+	}
+	return root
+}
+
+// directoryEntryNode creates the list item for a document, linking to it
+// with its title as the link text
+func (document *Document) directoryEntryNode(opts directoryOptions, entry IndexEntry, fallback string) *html.Node {
+	listItem := &html.Node{Type: html.ElementNode, Data: "li"}
+	link := &html.Node{
+		Type: html.ElementNode,
+		Data: "a",
+		Attr: []html.Attribute{
+			{Key: constants.HrefAttribute, Val: fmt.Sprintf("%v%v%v", document.Config.Routing.RoutePrefix, entry.FileName, document.Config.Routing.UrlFileSuffix)},
+			{Key: constants.RelAttribute, Val: constants.RelValue},
+		},
+	}
+	link.AppendChild(&html.Node{Type: html.TextNode, Data: entryTitle(entry, fallback)})
+	listItem.AppendChild(link)
+
+	if opts.Date && !entry.Date.IsZero() {
+		date := entry.Date.Format(constants.DirectoryDateFormat)
+		timeNode := &html.Node{
+			Type: html.ElementNode,
+			Data: "time",
+			Attr: []html.Attribute{{Key: "datetime", Val: date}},
+		}
+		timeNode.AppendChild(&html.Node{Type: html.TextNode, Data: date})
+		listItem.AppendChild(&html.Node{Type: html.TextNode, Data: " "})
+		listItem.AppendChild(timeNode)
+	}
+	if opts.Description && entry.Description != "" {
+		descriptionNode := &html.Node{Type: html.ElementNode, Data: constants.ParagraphNode}
+		descriptionNode.AppendChild(&html.Node{Type: html.TextNode, Data: entry.Description})
+		listItem.AppendChild(descriptionNode)
+	}
+
+	return listItem
+}
+
+// directoryTreeNode creates the nested <ul> for a directory
+func (document *Document) directoryTreeNode(opts directoryOptions, tree *directoryTree) *html.Node {
+	listNode := &html.Node{Type: html.ElementNode, Data: "ul"}
+	for _, item := range tree.items {
+		if item.entry != nil {
+			listNode.AppendChild(document.directoryEntryNode(opts, *item.entry, path.Base(item.entry.FileName)))
+			continue
+		}
+		listItem := &html.Node{Type: html.ElementNode, Data: "li"}
+		listItem.AppendChild(&html.Node{Type: html.TextNode, Data: item.dir.name})
+		listItem.AppendChild(document.directoryTreeNode(opts, item.dir))
+		listNode.AppendChild(listItem)
+	}
+	return listNode
+}
+
+// ProcessDirectoryNode creates an HTML listing of the available documents,
+// using their titles as link text. The attributes of the <directory> tag
+// select, sort and nest the listed documents.
+func (document *Document) ProcessDirectoryNode(n *html.Node) error {
+	document.UsesDirectory = true
+	if document.DocumentDirectory == nil {
+		return fmt.Errorf("document directory not initialized")
+	}
+
+	opts, err := parseDirectoryOptions(n)
+	if err != nil {
+		return err
+	}
+	entries := document.listDocuments(opts)
+
+	var listNode *html.Node
+	if opts.Tree {
+		listNode = document.directoryTreeNode(opts, buildDirectoryTree(opts, entries))
+	} else {
+		listNode = &html.Node{Type: html.ElementNode, Data: "ul"}
+		for _, entry := range entries {
+			listNode.AppendChild(document.directoryEntryNode(opts, entry, entry.FileName))
+		}
+	}
+
+	n.Parent.InsertBefore(listNode, n)
+	n.Parent.RemoveChild(n)
+
+	return nil
+}
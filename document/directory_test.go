@@ -0,0 +1,125 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+// TestDirectoryOptions validates that the attributes of the <directory> tag
+// select, sort and nest the listed documents
+func TestDirectoryOptions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Routing.RoutePrefix = "/"
+	directory := []string{"about", "blog/2020/old", "blog/a", "blog/b", "blog/draft", "index", ".hidden", "notes/.secret"}
+	index := Index{
+		"about":         {FileName: "about", Title: "About me", Weight: 2},
+		"blog/2020/old": {FileName: "blog/2020/old", Title: "Old post", Date: time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+		"blog/a":        {FileName: "blog/a", Title: "Zebras", Description: "All about zebras", Date: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), Weight: 1},
+		"blog/b":        {FileName: "blog/b", Title: "Aardvarks", Date: time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), Weight: 3},
+		"blog/draft":    {FileName: "blog/draft", Title: "Draft", Draft: true},
+		"index":         {FileName: "index", Title: "Home"},
+	}
+
+	tests := []struct {
+		TestName     string
+		InputHTML    string
+		ExpectOutput string
+		ExpectError  string
+	}{
+		{
+			"DirectoryOptions defaults",
+			`<directory></directory>`,
+			`<ul><li><a href="/about.html" rel="noopener noreferrer">About me</a></li><li><a href="/blog/2020/old.html" rel="noopener noreferrer">Old post</a></li><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a></li><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a></li><li><a href="/index.html" rel="noopener noreferrer">Home</a></li></ul>`,
+			"",
+		},
+		{
+			"DirectoryOptions path and depth",
+			`<directory path="/blog/" depth="1"></directory>`,
+			`<ul><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a></li><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a></li></ul>`,
+			"",
+		},
+		{
+			"DirectoryOptions sort by title",
+			`<directory path="blog" sort="title"></directory>`,
+			`<ul><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a></li><li><a href="/blog/2020/old.html" rel="noopener noreferrer">Old post</a></li><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a></li></ul>`,
+			"",
+		},
+		{
+			"DirectoryOptions newest first with dates, descriptions and a limit",
+			`<directory path="blog" sort="date" order="desc" limit="2" date="true" description="true"></directory>`,
+			`<ul><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a> <time datetime="2021-04-01">2021-04-01</time></li><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a> <time datetime="2021-03-01">2021-03-01</time><p>All about zebras</p></li></ul>`,
+			"",
+		},
+		{
+			"DirectoryOptions sort by weight and exclude",
+			`<directory sort="weight" exclude="index, blog/2020/*"></directory>`,
+			`<ul><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a></li><li><a href="/about.html" rel="noopener noreferrer">About me</a></li><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a></li></ul>`,
+			"",
+		},
+		{
+			"DirectoryOptions tree",
+			`<directory tree="true"></directory>`,
+			`<ul><li><a href="/about.html" rel="noopener noreferrer">About me</a></li><li>blog<ul><li>2020<ul><li><a href="/blog/2020/old.html" rel="noopener noreferrer">Old post</a></li></ul></li><li><a href="/blog/a.html" rel="noopener noreferrer">Zebras</a></li><li><a href="/blog/b.html" rel="noopener noreferrer">Aardvarks</a></li></ul></li><li><a href="/index.html" rel="noopener noreferrer">Home</a></li></ul>`,
+			"",
+		},
+		{"DirectoryOptions invalid depth", `<directory depth="-1"></directory>`, "", "directory attribute depth -1 must be a non-negative integer"},
+		{"DirectoryOptions invalid limit", `<directory limit="ten"></directory>`, "", "directory attribute limit ten must be a non-negative integer"},
+		{"DirectoryOptions invalid sort", `<directory sort="size"></directory>`, "", "directory attribute sort size must be one of name, title, date or weight"},
+		{"DirectoryOptions invalid order", `<directory order="up"></directory>`, "", "directory attribute order up must be either asc or desc"},
+		{"DirectoryOptions invalid tree", `<directory tree="yes please"></directory>`, "", "directory attribute tree yes please must be true or false"},
+		{"DirectoryOptions invalid exclude", `<directory exclude="blog/["></directory>`, "", "directory attribute exclude has invalid pattern blog/[: syntax error in pattern"},
+	}
+
+	for _, test := range tests {
+		document := Document{Config: &conf, DocumentDirectory: &directory, Index: index}
+
+		doc, err := html.Parse(strings.NewReader("<body>" + test.InputHTML + "</body>"))
+		require.NoError(err, test.TestName)
+
+		err = document.ProcessDirectoryNode(helpers.GetNodeOfType(doc, constants.DirectoryNode))
+		if test.ExpectError != "" {
+			assert.EqualError(err, test.ExpectError, test.TestName)
+			continue
+		}
+		require.NoError(err, test.TestName)
+		assert.True(document.UsesDirectory, test.TestName)
+
+		output, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+		require.NoError(err, test.TestName)
+		assert.Equal("<body>"+test.ExpectOutput+"</body>", output, test.TestName)
+	}
+}
+
+func TestReadIndexEntry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/export-docs"
+
+	entry, err := ReadIndexEntry(&conf, "blog/post")
+	require.NoError(err)
+	assert.Equal(IndexEntry{
+		FileName: "blog/post",
+		URL:      "/content/blog/post.html",
+		Title:    "Post",
+		Headings: []Heading{{Level: 1, ID: "post", Text: "Post"}},
+		Summary:  "A blog post.",
+		Links:    []string{},
+	}, entry)
+
+	_, err = ReadIndexEntry(&conf, "missing")
+	assert.Error(err)
+}
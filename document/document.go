@@ -4,13 +4,15 @@ import (
 	"lightsites/config"
 	"lightsites/constants"
 	"lightsites/helpers"
+	"lightsites/metrics"
 
 	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
-	"strconv"
+	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -21,23 +23,97 @@ import (
 )
 
 type Document struct {
-	FileName          string
-	FileContents      string
-	DocumentName      string
-	RenderedContents  string
-	Title             string
-	TitleURL          string
-	ID                string
-	DateCreated       time.Time
-	DateModified      time.Time
+	FileName         string
+	FileContents     string
+	DocumentName     string
+	RenderedContents string
+	Title            string
+	TitleURL         string
+	ID               string
+	DateCreated      time.Time
+	DateModified     time.Time
+	Description      string
+	Date             time.Time
+	Tags             []string
+	Draft            bool
+	Slug             string
+	Aliases          []string
+	Weight           int
+	// SitemapExclude leaves the document out of the sitemap, and
+	// SitemapPriority and SitemapChangeFreq are listed with it when set
+	SitemapExclude    bool
+	SitemapPriority   string
+	SitemapChangeFreq string
 	Attributes        map[string]string
+	// ETag is the HTTP entity tag of FileContents
+	ETag string
+	// Encodings holds FileContents compressed with each enabled content
+	// encoding, keyed by encoding. Encodings that wouldn't make the document
+	// smaller are left out.
+	Encodings map[string]string
+	// FrontMatter holds the values parsed from the document's YAML or TOML
+	// front matter, if it has any
+	FrontMatter map[string]interface{}
+	// Metadata holds the typed values of the document's attributes. Values
+	// from front matter keep their types, including lists and nested maps,
+	// while values from the <attributes> tag are strings.
+	Metadata          map[string]interface{}
 	DocumentDirectory *[]string
-	Config            *config.Config
+	// Index holds the metadata of every document in the site, such as the
+	// titles listed by <directory> tags
+	Index  Index
+	Config *config.Config
+	// Templates lists the template files used by the document, relative to
+	// the templates directory, in the order they were first used
+	Templates []string
+	// UsesDirectory is set when the document contains a <directory> tag,
+	// meaning that its contents depend on the list of documents
+	UsesDirectory bool
+	// UsesIndex is set when the document's contents depend on the metadata
+	// of other documents, through html/template templates or links to other
+	// documents
+	UsesIndex bool
+	// Stale is set when the document failed to render, and its previous
+	// successful render is being served instead
+	Stale bool
+	// Violations lists the parts of the document that break the
+	// no-JavaScript policy, whether or not they were removed
+	Violations []Violation
+}
+
+// AddTemplateDependency records that the document uses a template file, so
+// that the document can be re-rendered when that template changes
+func (document *Document) AddTemplateDependency(templateFile string) {
+	templateFile = path.Clean(templateFile)
+	for _, existing := range document.Templates {
+		if existing == templateFile {
+			return
+		}
+	}
+	document.Templates = append(document.Templates, templateFile)
+}
+
+// DependsOnIndex returns true if the document has to be rendered again when
+// documents are created or deleted, or their metadata changes
+func (document *Document) DependsOnIndex() bool {
+	return document.UsesDirectory || document.UsesIndex
+}
+
+// DependsOnTemplate returns true if the document uses the template file
+func (document *Document) DependsOnTemplate(templateFile string) bool {
+	templateFile = path.Clean(templateFile)
+	for _, existing := range document.Templates {
+		if existing == templateFile {
+			return true
+		}
+	}
+	return false
 }
 
 // ProcessAttributes parses an input HTML node recursively for something
 // like an `<attributes title="Document Title"></attributes>` tag. It will
-// also assign the attribute values to the document.Attributes map.
+// also assign the attribute values to the document.Attributes map. Values
+// from the tag take precedence over any front matter values.
 //
 // The value of htmlNode should be the output of:
 //
@@ -46,8 +122,10 @@ type Document struct {
 // where htmlstr is the full contents of an HTML document as a string.
 func (document *Document) ProcessAttributes(htmlNode *html.Node) error {
 	// take note of whether or not this htmlNode contains the title attributes
-	// tags - if not, throw an error
-	containsTitleAttribute := false
+	// tags - if not, throw an error. The title may also have been set by
+	// front matter already.
+	_, ok := document.FrontMatter[constants.TitleAttribute]
+	containsTitleAttribute := ok && metadataString(document.FrontMatter[constants.TitleAttribute]) != ""
 
 	var f func(*html.Node)
 	f = func(n *html.Node) {
@@ -86,9 +164,21 @@ func (document *Document) ProcessAttributes(htmlNode *html.Node) error {
 }
 
 // ProcessBodyNode sets up the <body> tag with a bootstrap-compatible grid,
-// which is important because a Markdown->HTML document just contains
-// <p> and <h1> tags (for example), but not anything layout-related
+// which is important because a Markdown->HTML document just contains <p>
+// and <h1> tags (for example), but not anything layout-related. The header
+// region is placed above the grid, and the sidebar and footer below it.
+// Documents with a layout already had it applied by ProcessLayout, so their
+// body is left as it is.
 func (document *Document) ProcessBodyNode(n *html.Node) error {
+	if document.Layout() != "" {
+		return nil
+	}
+
+	regions, err := collectRegions(n)
+	if err != nil {
+		return err
+	}
+
 	// create the nodes that make up the grid layout
 	containerNode := &html.Node{
 		Type: html.ElementNode,
@@ -142,11 +232,20 @@ func (document *Document) ProcessBodyNode(n *html.Node) error {
 		} else {
 			colNode.AppendChild(bodyLastChild)
 		}
-		return nil
 	} else {
 		// scenario 1 - easy
 		n.AppendChild(containerNode)
 	}
+
+	for _, region := range constants.LayoutRegions {
+		for _, regionChild := range regions[region] {
+			if region == constants.RegionHeader {
+				n.InsertBefore(regionChild, containerNode)
+			} else {
+				n.AppendChild(regionChild)
+			}
+		}
+	}
 	return nil
 }
 
@@ -217,40 +316,6 @@ func (document *Document) ProcessTableNode(n *html.Node) error {
 	return nil
 }
 
-// ProcessDirectoryNode creates an HTML listing of all available documents
-func (document *Document) ProcessDirectoryNode(n *html.Node) error {
-	if document.DocumentDirectory == nil {
-		return fmt.Errorf("document directory not initialized")
-	}
-	listNode := &html.Node{Type: html.ElementNode, Data: "ul"}
-	for _, doc := range *document.DocumentDirectory {
-		// don't show hidden files
-		if strings.Index(doc, ".") == 0 {
-			continue
-		}
-		newListItem := &html.Node{Type: html.ElementNode, Data: "li"}
-		newListItemLink := &html.Node{
-			Type: html.ElementNode,
-			Data: "a",
-			Attr: []html.Attribute{
-				{Key: constants.HrefAttribute, Val: fmt.Sprintf("%v%v%v", document.Config.Routing.RoutePrefix, doc, document.Config.Routing.UrlFileSuffix)},
-				{Key: constants.RelAttribute, Val: constants.RelValue},
-			},
-		}
-		newListItemLinkContent := &html.Node{
-			Type: html.TextNode,
-			Data: doc,
-		}
-		newListItemLink.AppendChild(newListItemLinkContent)
-		newListItem.AppendChild(newListItemLink)
-		listNode.AppendChild(newListItem)
-	}
-	n.Parent.InsertBefore(listNode, n)
-	n.Parent.RemoveChild(n)
-
-	return nil
-}
-
 // ProcessNode applies rules to nodes in a generalized manner, according
 // to the configured rules
 func (document *Document) ProcessNode(n *html.Node) {
@@ -271,8 +336,19 @@ func (document *Document) ProcessNode(n *html.Node) {
 	}
 }
 
-// ProcessTemplateNode applies a template to a <template> node
+// ProcessTemplateNode applies a template to a <template> node. Templates
+// may use other templates, which are expanded before the template is
+// inserted into the document.
 func (document *Document) ProcessTemplateNode(n *html.Node, parentDoc *html.Node) error {
+	return document.processTemplateNode(n, parentDoc, []string{})
+}
+
+// processTemplateNode applies a template to a <template> node. chain lists
+// the template files that include the node, outermost first, and is used to
+// detect cycles and enforce the maximum nesting depth. Variables are not
+// inherited from the including templates; they have to be passed down as
+// attributes of the nested <template> tag.
+func (document *Document) processTemplateNode(n *html.Node, parentDoc *html.Node, chain []string) error {
 	templateAttributes := make(map[string]string)
 
 	for _, attr := range n.Attr {
@@ -284,63 +360,146 @@ func (document *Document) ProcessTemplateNode(n *html.Node, parentDoc *html.Node
 		return fmt.Errorf("must specify template HTML attribute %v, none was specified", constants.TemplateFileKey)
 	}
 
-	content, err := ioutil.ReadFile(fmt.Sprintf("%v/%v", document.Config.Directories.Templates, templateAttributes[constants.TemplateFileKey]))
+	// templates in the inner content of the tag are used by whoever uses
+	// the tag, not by the template, so they are expanded with the caller's
+	// chain before the content fills the template's slots
+	for nested := innerTemplate(n); nested != nil; nested = innerTemplate(n) {
+		err := document.processTemplateNode(nested, parentDoc, chain)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the inner content of the tag fills the template's slots
+	slots, err := templateSlots(n)
 	if err != nil {
-		return fmt.Errorf("failed to read template file %v: %v", templateAttributes[constants.TemplateFileKey], err.Error())
+		return fmt.Errorf("failed to read slots for template %v: %v", templateAttributes[constants.TemplateFileKey], err.Error())
 	}
 
-	contentStr := string(content)
-	// replace the values in the template with their rendered value
-	for templateKey, templateVal := range templateAttributes {
-		contentStr = strings.ReplaceAll(contentStr, fmt.Sprintf("{{%v}}", templateKey), templateVal)
+	container, err := document.expandTemplate(templateAttributes[constants.TemplateFileKey], templateAttributes, slots, parentDoc, chain)
+	if err != nil {
+		return err
 	}
 
-	// render the template contentStr as HTML
-	templateHTMLNodes, err := html.Parse(strings.NewReader(contentStr))
+	// templates in the document itself can be sent to a region of the
+	// layout instead, such as the header. Nested templates, including the
+	// ones used by layouts, are always inserted in place.
+	region, err := templateRegion(templateAttributes)
 	if err != nil {
-		return fmt.Errorf("failed to parse template html: %v", err.Error())
+		return fmt.Errorf("invalid use of template %v: %v", templateAttributes[constants.TemplateFileKey], err.Error())
+	}
+	if region == "" || len(chain) > 0 {
+		moveChildren(container, n.Parent, n)
+		n.Parent.RemoveChild(n)
+		return nil
 	}
 
-	// the HTML parse function returns something like
-	// <html><head></head><body><rendered template></body></html>
-	//
-	// So, get the <body> node, so that we can extract only its child,
-	// which is the rendered template
-	renderedTemplateBodyNode := helpers.GetNodeOfType(templateHTMLNodes, constants.BodyNode)
-	if renderedTemplateBodyNode == nil || renderedTemplateBodyNode.FirstChild == nil {
-		return fmt.Errorf("unable to identify rendered template body or parent node for template %v", templateAttributes[constants.TemplateFileKey])
+	moveToRegion(n, container, region)
+
+	return nil
+}
+
+// innerTemplate returns the first <template> node in the inner content of
+// the template node n, if any
+func innerTemplate(n *html.Node) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		nested := helpers.GetNodeOfType(c, constants.TemplateNode)
+		if nested != nil {
+			return nested
+		}
 	}
-	renderedTemplateNode := renderedTemplateBodyNode.FirstChild
-	renderedTemplateNode.Parent.RemoveChild(renderedTemplateNode)
+	return nil
+}
 
-	// check if the "heading" variable is set to true
-	_, ok = templateAttributes[constants.TemplateHeadingKey]
-	if !ok {
-		// The heading variable hasn't been set, so it's ok to render
-		// the template here.
-		// The FirstChild of the renderedTemplateBodyNode is the template
-		// itself, so we insert it accordingly
-		n.Parent.InsertBefore(renderedTemplateNode, n)
-		n.Parent.RemoveChild(n)
+// expandTemplate renders a template file with the given attributes and
+// slots, and returns its nodes, with any templates it uses expanded, as
+// the children of a container node. Anything in the template's <head>
+// block is added to the head of parentDoc.
+func (document *Document) expandTemplate(file string, attributes map[string]string, slots map[string]string, parentDoc *html.Node, chain []string) (*html.Node, error) {
+	templateFile := path.Clean(file)
+
+	includes := append(append([]string{}, chain...), templateFile)
+	for _, included := range chain {
+		if included == templateFile {
+			return nil, fmt.Errorf("template cycle detected: %v", strings.Join(includes, " -> "))
+		}
+	}
+	maxDepth := document.Config.Templates.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = constants.DefaultTemplateMaxDepth
+	}
+	if len(includes) > maxDepth {
+		return nil, fmt.Errorf("templates are nested deeper than the maximum depth of %v: %v", maxDepth, strings.Join(includes, " -> "))
+	}
 
-		return nil
+	// record the dependency before reading the file, so that a document
+	// using a missing template is re-rendered once the template is created
+	document.AddTemplateDependency(templateFile)
+
+	metrics.TemplateReads.Inc(templateFile)
+	content, err := ioutil.ReadFile(fmt.Sprintf("%v/%v", document.Config.Directories.Templates, file))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template file %v: %v", file, err.Error())
 	}
 
-	// if the template is a heading, it needs to be rendered first
-	isHeading, err := strconv.ParseBool(templateAttributes[constants.TemplateHeadingKey])
-	if err != nil || !isHeading {
-		n.Parent.InsertBefore(renderedTemplateNode, n)
-		n.Parent.RemoveChild(n)
-		return nil
+	// check the attributes against the parameters the template declares,
+	// and fill in the defaults of the ones that weren't passed
+	params, content, err := SplitTemplateParams(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parameters of template %v: %v", file, err.Error())
+	}
+	err = applyTemplateParams(params, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid use of template %v: %v", file, err.Error())
 	}
 
-	// the final logic path is where the heading value is set to true,
-	// so push this template to the top of the page
-	bodyNode := helpers.GetNodeOfType(parentDoc, constants.BodyNode)
-	bodyNode.InsertBefore(renderedTemplateNode, bodyNode.FirstChild)
-	n.Parent.RemoveChild(n)
+	// an empty {{content}} falls back to the content attribute, if any
+	_, hasContentAttribute := attributes[constants.DefaultSlot]
+	if slots[constants.DefaultSlot] == "" && hasContentAttribute {
+		delete(slots, constants.DefaultSlot)
+	}
 
-	return nil
+	// replace the variables in the template with their escaped values, or
+	// execute it with html/template
+	contentStr, err := document.renderTemplate(file, string(content), attributes, slots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template %v: %v", file, err.Error())
+	}
+
+	// render the template contentStr as HTML fragments, one for the page
+	// <head> and one for where the template is used
+	headNodes, bodyNodes, err := parseTemplateHTML(contentStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template html: %v", err.Error())
+	}
+	if len(bodyNodes) == 0 && len(headNodes) == 0 {
+		return nil, fmt.Errorf("template %v has no content", file)
+	}
+	document.hoistHeadNodes(parentDoc, headNodes)
+
+	// expand the templates used by this template. They are held in a
+	// container, since a nested template may itself be a top-level node.
+	container := &html.Node{Type: html.ElementNode, Data: constants.DivNode}
+	for _, node := range bodyNodes {
+		container.AppendChild(node)
+	}
+	for nested := helpers.GetNodeOfType(container, constants.TemplateNode); nested != nil; nested = helpers.GetNodeOfType(container, constants.TemplateNode) {
+		err = document.processTemplateNode(nested, parentDoc, includes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return container, nil
+}
+
+// moveChildren moves every child of from into parent, in order, before the
+// node before. A nil before appends them to the end of parent.
+func moveChildren(from *html.Node, parent *html.Node, before *html.Node) {
+	for c := from.FirstChild; c != nil; c = from.FirstChild {
+		from.RemoveChild(c)
+		parent.InsertBefore(c, before)
+	}
 }
 
 // ProcessNodesOfType is the decision tree for special-case HTML elements,
@@ -359,7 +518,18 @@ func (document *Document) ProcessNodesOfType(htmlstr string, nodeType string) (o
 		return output, fmt.Errorf("failed to parse html: %v", err.Error())
 	}
 
-	for n := helpers.GetNodeOfType(doc, nodeType); n != nil; n = helpers.GetNodeOfType(doc, nodeType) {
+	// a processed <table> stays in the tree, so tables are collected before
+	// any of them is processed rather than looked up one at a time
+	if nodeType == constants.TableNode {
+		for _, n := range helpers.GetNodesOfType(doc, nodeType) {
+			err = document.ProcessTableNode(n)
+			if err != nil {
+				return output, fmt.Errorf("failed to process %v node: %v", constants.TableNode, err.Error())
+			}
+		}
+	}
+
+	for n := helpers.GetNodeOfType(doc, nodeType); n != nil && nodeType != constants.TableNode; n = helpers.GetNodeOfType(doc, nodeType) {
 		switch nodeType {
 		case constants.TemplateNode:
 			err = document.ProcessTemplateNode(n, doc)
@@ -371,11 +541,6 @@ func (document *Document) ProcessNodesOfType(htmlstr string, nodeType string) (o
 			if err != nil {
 				return output, fmt.Errorf("failed to process %v node: %v", constants.DirectoryNode, err.Error())
 			}
-		case constants.TableNode:
-			err = document.ProcessTableNode(n)
-			if err != nil {
-				return output, fmt.Errorf("failed to process %v node: %v", constants.TableNode, err.Error())
-			}
 		}
 	}
 
@@ -403,6 +568,11 @@ func (document *Document) ProcessHTMLTree(htmlstr string) (output string, err er
 		return output, fmt.Errorf("failed to process HTML tree: %v", err.Error())
 	}
 
+	err = document.ProcessMetadata()
+	if err != nil {
+		return output, fmt.Errorf("failed to process HTML tree: %v", err.Error())
+	}
+
 	// render to HTML doc to a string
 	wipHTML, err := helpers.RenderNode(doc)
 	if err != nil {
@@ -415,12 +585,19 @@ func (document *Document) ProcessHTMLTree(htmlstr string) (output string, err er
 		return output, fmt.Errorf("failed to process %v nodes: %v", constants.TemplateNode, err.Error())
 	}
 
-	directoryProcessedHTML, err := document.ProcessNodesOfType(templatedHTML, constants.DirectoryNode)
+	// the layout is applied before the <directory> and <table> passes, so
+	// that they cover the layout as well as the document
+	layoutHTML, err := document.ProcessLayout(templatedHTML)
+	if err != nil {
+		return output, fmt.Errorf("failed to process %v node: %v", constants.BodyNode, err.Error())
+	}
+
+	directoryProcessedHTML, err := document.ProcessNodesOfType(layoutHTML, constants.DirectoryNode)
 	if err != nil {
 		return output, fmt.Errorf("failed to process %v nodes: %v", constants.DirectoryNode, err.Error())
 	}
 
-	tableProcessedHTML, err := document.ProcessNodesOfType(directoryProcessedHTML, constants.DirectoryNode)
+	tableProcessedHTML, err := document.ProcessNodesOfType(directoryProcessedHTML, constants.TableNode)
 	if err != nil {
 		return output, fmt.Errorf("failed to process %v nodes: %v", constants.TableNode, err.Error())
 	}
@@ -431,7 +608,12 @@ func (document *Document) ProcessHTMLTree(htmlstr string) (output string, err er
 		return output, fmt.Errorf("failed to parse html: %v", err.Error())
 	}
 
-	// general manipulation
+	// links to other documents' markdown files are pointed at their routes
+	document.ProcessDocumentLinks(doc)
+
+	// general manipulation. The body is where regions are placed around the
+	// grid, so failing to process it fails the document.
+	var bodyErr error
 	var f func(*html.Node)
 	f = func(n *html.Node) {
 		if n.Type == html.ElementNode {
@@ -440,7 +622,7 @@ func (document *Document) ProcessHTMLTree(htmlstr string) (output string, err er
 			case constants.BodyNode:
 				err := document.ProcessBodyNode(n)
 				if err != nil {
-					log.Printf("failed to process %v node: %v", constants.BodyNode, err.Error())
+					bodyErr = err
 				}
 			case constants.HeadNode:
 				err := document.ProcessHeadNode(n)
@@ -461,12 +643,21 @@ func (document *Document) ProcessHTMLTree(htmlstr string) (output string, err er
 	}
 
 	f(doc)
+	if bodyErr != nil {
+		return output, fmt.Errorf("failed to process %v node: %v", constants.BodyNode, bodyErr.Error())
+	}
 
 	// render the doc
 	if doc == nil {
 		return "", fmt.Errorf("doc is nil")
 	}
 
+	// sanitize last, so that anything added by templates is covered too
+	err = document.Sanitize(doc)
+	if err != nil {
+		return output, fmt.Errorf("failed to sanitize html: %v", err.Error())
+	}
+
 	var buf bytes.Buffer
 	w := io.Writer(&buf)
 	err = html.Render(w, doc)
@@ -484,47 +675,100 @@ func GetMarkdownHTMLFlags() mdhtml.Flags {
 	return mdhtml.CommonFlags | mdhtml.CompletePage | mdhtml.NoopenerLinks | mdhtml.NoreferrerLinks | mdhtml.HrefTargetBlank | mdhtml.FootnoteReturnLinks | mdhtml.Smartypants | mdhtml.SmartypantsFractions | mdhtml.SmartypantsDashes | mdhtml.SmartypantsLatexDashes /* | mdhtml.TOC */
 }
 
-func ParseDocument(conf *config.Config, documents *[]Document, documentDirectory *[]string, fileName string) (finalMarkdown string, err error) {
-	newDoc := Document{
-		FileName: fileName,
-		// FileContents: finalMarkdown,
-		// DocumentName: documentName,
+// renderMarkdown renders markdown to a complete HTML page
+func renderMarkdown(content []byte) []byte {
+	// configure the markdown parser and renderer
+	MDParser := parser.NewWithExtensions(GetMarkdownExtensionsConfig())
+
+	opts := mdhtml.RendererOptions{
+		HeadingIDPrefix: "",
+		Flags:           GetMarkdownHTMLFlags(),
+	}
+	MDRenderer := mdhtml.NewRenderer(opts)
+
+	return markdown.ToHTML(content, MDParser, MDRenderer)
+}
+
+// ParseDocument reads and renders a single markdown document, and appends it
+// to documents if it was rendered successfully. A document that fails to
+// render is not appended, so that it can't be served with empty contents.
+func ParseDocument(conf *config.Config, documents *[]Document, documentDirectory *[]string, index Index, fileName string) (finalMarkdown string, err error) {
+	doc, err := NewDocument(conf, documentDirectory, index, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	*documents = append(*documents, doc)
+
+	return doc.FileContents, nil
+}
+
+// NewDocument reads and renders a single markdown document. If rendering
+// fails, the partially processed document is still returned along with the
+// error, since the dependencies that were recorded before the failure are
+// needed to know when to try again.
+func NewDocument(conf *config.Config, documentDirectory *[]string, index Index, fileName string) (doc Document, err error) {
+	doc = Document{
+		FileName:          fileName,
 		ID:                fileName,
 		Attributes:        make(map[string]string),
 		DocumentDirectory: documentDirectory,
+		Index:             index,
 		Config:            conf,
 	}
-	*documents = append(*documents, newDoc)
-	newDocIndex := len(*documents) - 1
-
-	(*documents)[newDocIndex].FileContents = finalMarkdown
 
 	// read the file
 	content, err := ioutil.ReadFile(fmt.Sprintf("%v/%v%v", conf.Directories.Documents, fileName, constants.MarkdownFileSuffix))
-	// content, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		return "", fmt.Errorf("failed to read file %v: %v", fileName, err.Error())
+		return doc, fmt.Errorf("failed to read file %v: %v", fileName, err.Error())
 	}
 	// trim leading whitespace from the file
 	content = []byte(strings.TrimLeft(string(content), "\n"))
-	(*documents)[newDocIndex].DocumentName = strings.TrimRight(fileName, ".md")
+	doc.DocumentName = strings.TrimSuffix(fileName, constants.MarkdownFileSuffix)
 
-	// configure the markdown parser and renderer
-	MDParser := parser.NewWithExtensions(GetMarkdownExtensionsConfig())
+	// front matter has to be removed before the markdown is rendered
+	frontMatter, content, err := SplitFrontMatter(content)
+	if err != nil {
+		return doc, fmt.Errorf("failed to read front matter for file %v: %v", fileName, err.Error())
+	}
+	doc.SetFrontMatter(frontMatter)
 
-	opts := mdhtml.RendererOptions{
-		HeadingIDPrefix: "",
-		Flags:           GetMarkdownHTMLFlags(),
+	renderedMarkdown := renderMarkdown(content)
+	finalMarkdown, err := doc.ProcessHTMLTree(string(renderedMarkdown))
+	if err != nil {
+		return doc, fmt.Errorf("failed to process HTML tree for file %v: %v", fileName, err.Error())
 	}
-	MDRenderer := mdhtml.NewRenderer(opts)
 
-	renderedMarkdown := markdown.ToHTML(content, MDParser, MDRenderer)
-	finalMarkdown, err = newDoc.ProcessHTMLTree(string(renderedMarkdown))
+	doc.FileContents = finalMarkdown
+	doc.ETag = helpers.ETag(doc.FileContents)
+
+	doc.DateModified, err = doc.GetDateModified()
 	if err != nil {
-		return "", fmt.Errorf("failed to process HTML tree for file %v: %v", fileName, err.Error())
+		return doc, fmt.Errorf("failed to get modification time for file %v: %v", fileName, err.Error())
+	}
+
+	return doc, nil
+}
+
+// GetDateModified returns the latest modification time of the document's
+// source file and the templates it uses, since a change to either changes
+// the rendered document
+func (document *Document) GetDateModified() (time.Time, error) {
+	files := []string{fmt.Sprintf("%v/%v%v", document.Config.Directories.Documents, document.FileName, constants.MarkdownFileSuffix)}
+	for _, templateFile := range document.Templates {
+		files = append(files, fmt.Sprintf("%v/%v", document.Config.Directories.Templates, templateFile))
 	}
 
-	(*documents)[newDocIndex].FileContents = finalMarkdown
+	modified := time.Time{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return modified, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
 
-	return finalMarkdown, nil
+	return modified, nil
 }
@@ -8,8 +8,11 @@ import (
 	"lightsites/helpers"
 
 	"fmt"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -208,7 +211,7 @@ func TestProcessTemplateNode(t *testing.T) {
 			"ProcessTemplateNode happy path heading true",
 			&defaultDocument,
 			`<body><span>Hello</span><template file="alert.html" heading="true" alert-text="Heads up!"></template></body>`,
-			`<html><head></head><body><div class="alert alert-primary">Heads up!</div><span>Hello</span></body></html>`,
+			`<html><head></head><body><span>Hello</span><region name="header"><div class="alert alert-primary">Heads up!</div></region></body></html>`,
 			false,
 		},
 		{
@@ -218,6 +221,41 @@ func TestProcessTemplateNode(t *testing.T) {
 			`<html><head></head><body><span>Hello</span><div class="alert alert-primary">Heads up!</div></body></html>`,
 			false,
 		},
+		{
+			"ProcessTemplateNode escapes markup in variables",
+			&defaultDocument,
+			`<body><template file="alert.html" alert-text="<b>Heads up!</b>"></template></body>`,
+			`<html><head></head><body><div class="alert alert-primary">&lt;b&gt;Heads up!&lt;/b&gt;</div></body></html>`,
+			false,
+		},
+		{
+			"ProcessTemplateNode missing variable",
+			&defaultDocument,
+			`<body><span>Hello</span><template file="alert.html"></template></body>`,
+			`<html><head></head><body><span>Hello</span><template file="alert.html"></template></body></html>`,
+			true,
+		},
+		{
+			"ProcessTemplateNode nested templates",
+			&defaultDocument,
+			`<body><template file="card.html" card-title="Post" badge="<new>" href="/post.html"></template></body>`,
+			`<html><head></head><body><div class="card"><div class="card-body"><h5>Post <span class="badge badge-secondary">&lt;new&gt;</span></h5><a class="btn btn-primary" href="/post.html">Read more</a></div></div></body></html>`,
+			false,
+		},
+		{
+			"ProcessTemplateNode same template in its own content",
+			&defaultDocument,
+			`<body><template file="callout.html">outer <template file="callout.html">inner<slot name="footer">b</slot></template><slot name="footer">a</slot></template></body>`,
+			`<html><head></head><body><div class="callout"><div class="callout-body">outer <div class="callout"><div class="callout-body">inner</div><footer>b</footer></div></div><footer>a</footer></div></body></html>`,
+			false,
+		},
+		{
+			"ProcessTemplateNode template cycle",
+			&defaultDocument,
+			`<body><template file="cycle-a.html"></template></body>`,
+			`<html><head></head><body><template file="cycle-a.html"></template></body></html>`,
+			true,
+		},
 		{
 			"ProcessTemplateNode no file specified",
 			&defaultDocument,
@@ -312,6 +350,17 @@ func TestProcessBodyNode(t *testing.T) {
 				defaultDocument.Config.BodyConfig.ColClass,
 			),
 		},
+		{
+			"ProcessBodyNode regions around the grid",
+			&defaultDocument,
+			`<body><p>Test</p><p><region name="footer">Bye</region></p><region name="header"><h1>Hi</h1></region><region name="sidebar"><p>Side</p></region></body>`,
+			fmt.Sprintf(
+				`<html><head></head><body><h1>Hi</h1><div class="%v"><div class="%v"><div class="%v"><p>Test</p></div></div></div><p>Side</p>Bye</body></html>`,
+				defaultDocument.Config.BodyConfig.ContainerClass,
+				defaultDocument.Config.BodyConfig.RowClass,
+				defaultDocument.Config.BodyConfig.ColClass,
+			),
+		},
 	}
 
 	for _, test := range tests {
@@ -557,7 +606,7 @@ func TestProcessHTMLTree(t *testing.T) {
 			"ProcessHTMLTree scenario happy path",
 			&defaultDocument,
 			`<html><head></head><body><attributes title="Your Document Title"></attributes><template file="alert.html" heading="false" alert-text="Heads up!"></template><table><tr><th></th></tr><tr><td></td></tr></table><img src="test.jpg"/><directory></directory></body></html>`,
-			`<html><head><link href="/assets/bootstrap.min.css" rel="stylesheet" crossorigin="anonymous"/><link href="/assets/custom.css" rel="stylesheet" crossorigin="anonymous"/><title>Your Document Title</title></head><body><div class="container"><div class="row"><div class="col-lg-12"><div class="alert alert-primary">Heads up!</div><table><tbody><tr><th></th></tr><tr><td></td></tr></tbody></table><img src="test.jpg" style="max-width: 100%;"/><ul><li><a href="/content/test1.html" rel="noopener noreferrer">test1</a></li><li><a href="/content/test2.html" rel="noopener noreferrer">test2</a></li></ul></div></div></div></body></html>`,
+			`<html><head><link href="/assets/bootstrap.min.css" rel="stylesheet" crossorigin="anonymous"/><link href="/assets/custom.css" rel="stylesheet" crossorigin="anonymous"/><title>Your Document Title</title></head><body><div class="container"><div class="row"><div class="col-lg-12"><div class="alert alert-primary">Heads up!</div><div class="table-responsive"><table class="table table-bordered table-striped table-hover table-sm"><tbody><tr><th></th></tr><tr><td></td></tr></tbody></table></div><img src="test.jpg" style="max-width: 100%;"/><ul><li><a href="/content/test1.html" rel="noopener noreferrer">test1</a></li><li><a href="/content/test2.html" rel="noopener noreferrer">test2</a></li></ul></div></div></div></body></html>`,
 			false,
 		},
 		{
@@ -687,7 +736,7 @@ func TestParseDocument(t *testing.T) {
 
 <p></p><div class="alert alert-primary">Hi</div><p></p>
 
-<p></p><ul><li><a href="/content/test2.html" rel="noopener noreferrer">test2</a></li><li><a href="/content/test1.html" rel="noopener noreferrer">test1</a></li></ul><p></p>
+<p></p><ul><li><a href="/content/test1.html" rel="noopener noreferrer">test1</a></li><li><a href="/content/test2.html" rel="noopener noreferrer">test2</a></li></ul><p></p>
 
 
 
@@ -710,6 +759,7 @@ func TestParseDocument(t *testing.T) {
 			test.InputConf,
 			test.InputDocuments,
 			test.InputDocumentDirectory,
+			nil,
 			test.InputFileName,
 		)
 		if test.ExpectError {
@@ -721,3 +771,93 @@ func TestParseDocument(t *testing.T) {
 		assert.Equal(test.OutputString, actual, test.TestName)
 	}
 }
+
+// TestParseDocumentDependencies validates that the templates and directory
+// listings used by a document are recorded
+func TestParseDocumentDependencies(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defaultConfig := config.GetDefaultConfig()
+	defaultConfig.Directories.Templates = "../tests/templates"
+	defaultConfig.Directories.Documents = "../tests/export-docs"
+	documentDirectory := []string{"index", "blog/post"}
+	documents := []Document{}
+
+	for _, file := range documentDirectory {
+		_, err := ParseDocument(&defaultConfig, &documents, &documentDirectory, nil, file)
+		require.NoError(err)
+	}
+
+	require.Len(documents, 2)
+	assert.Equal([]string(nil), documents[0].Templates)
+	assert.True(documents[0].UsesDirectory)
+	assert.Equal([]string{"alert.html"}, documents[1].Templates)
+	assert.False(documents[1].UsesDirectory)
+	assert.True(documents[1].DependsOnTemplate("./alert.html"))
+	assert.False(documents[1].DependsOnTemplate("invalid.html"))
+}
+
+// TestNewDocumentFailure validates that a document that fails to render is
+// returned with the dependencies recorded up to the failure, and that
+// ParseDocument doesn't register it
+func TestNewDocumentFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultConfig := config.GetDefaultConfig()
+	defaultConfig.Directories.Templates = "../tests/does-not-exist"
+	defaultConfig.Directories.Documents = "../tests/export-docs"
+	documentDirectory := []string{"index", "blog/post"}
+
+	doc, err := NewDocument(&defaultConfig, &documentDirectory, nil, "blog/post")
+	assert.Error(err)
+	assert.Equal("blog/post", doc.FileName)
+	assert.Equal([]string{"alert.html"}, doc.Templates)
+	assert.Equal("", doc.FileContents)
+
+	documents := []Document{}
+	_, err = ParseDocument(&defaultConfig, &documents, &documentDirectory, nil, "blog/post")
+	assert.Error(err)
+	assert.Len(documents, 0)
+}
+
+// TestGetDateModified validates that a document is considered modified when
+// either its source file or one of its templates changes
+func TestGetDateModified(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "lightsites-modified")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	defaultConfig := config.GetDefaultConfig()
+	defaultConfig.Directories.Documents = dir + "/docs"
+	defaultConfig.Directories.Templates = dir + "/templates"
+	require.NoError(helpers.CopyDirectory("../tests/export-docs", defaultConfig.Directories.Documents))
+	require.NoError(helpers.CopyDirectory("../tests/templates", defaultConfig.Directories.Templates))
+
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	documentDirectory := []string{"index", "blog/post"}
+
+	tests := []struct {
+		TestName       string
+		InputDocument  time.Time
+		InputTemplate  time.Time
+		ExpectModified time.Time
+	}{
+		{"GetDateModified document changed last", newer, older, newer},
+		{"GetDateModified template changed last", older, newer, newer},
+	}
+
+	for _, test := range tests {
+		require.NoError(os.Chtimes(defaultConfig.Directories.Documents+"/blog/post.md", test.InputDocument, test.InputDocument), test.TestName)
+		require.NoError(os.Chtimes(defaultConfig.Directories.Templates+"/alert.html", test.InputTemplate, test.InputTemplate), test.TestName)
+
+		doc, err := NewDocument(&defaultConfig, &documentDirectory, nil, "blog/post")
+		require.NoError(err, test.TestName)
+		assert.True(test.ExpectModified.Equal(doc.DateModified), test.TestName)
+		assert.Equal(helpers.ETag(doc.FileContents), doc.ETag, test.TestName)
+	}
+}
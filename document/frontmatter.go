@@ -0,0 +1,285 @@
+package document
+
+import (
+	"lightsites/constants"
+
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// dateLayouts are the formats accepted for the date attribute when it is
+// given as a string
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// SplitFrontMatter separates a YAML (delimited by `---` lines) or TOML
+// (delimited by `+++` lines) front matter block from the start of a markdown
+// document. The parsed front matter is returned along with the remaining
+// markdown. If the document doesn't start with a front matter delimiter, the
+// returned front matter is nil and the markdown is returned unchanged.
+func SplitFrontMatter(content []byte) (frontMatter map[string]interface{}, body []byte, err error) {
+	lines := strings.SplitAfter(string(content), "\n")
+	if len(lines) == 0 {
+		return nil, content, nil
+	}
+
+	delimiter := strings.TrimSpace(lines[0])
+	if delimiter != constants.YAMLFrontMatterDelimiter && delimiter != constants.TOMLFrontMatterDelimiter {
+		return nil, content, nil
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == delimiter {
+			end = i
+			break
+		}
+	}
+	if end == -1 {
+		return nil, content, fmt.Errorf("front matter starting with %v is not terminated", delimiter)
+	}
+
+	raw := []byte(strings.Join(lines[1:end], ""))
+	body = []byte(strings.Join(lines[end+1:], ""))
+
+	frontMatter = make(map[string]interface{})
+	switch delimiter {
+	case constants.YAMLFrontMatterDelimiter:
+		parsed := make(map[interface{}]interface{})
+		err = yaml.Unmarshal(raw, &parsed)
+		if err != nil {
+			return nil, content, fmt.Errorf("failed to parse YAML front matter: %v", err.Error())
+		}
+		for key, val := range parsed {
+			frontMatter[fmt.Sprintf("%v", key)] = normalizeFrontMatterValue(val)
+		}
+	case constants.TOMLFrontMatterDelimiter:
+		_, err = toml.DecodeReader(bytes.NewReader(raw), &frontMatter)
+		if err != nil {
+			return nil, content, fmt.Errorf("failed to parse TOML front matter: %v", err.Error())
+		}
+		for key, val := range frontMatter {
+			frontMatter[key] = normalizeFrontMatterValue(val)
+		}
+	}
+
+	return frontMatter, body, nil
+}
+
+// normalizeFrontMatterValue converts the nested maps produced by the YAML
+// and TOML decoders to map[string]interface{}, and lists to
+// []interface{}, so that templates see the same types regardless of
+// which front matter format was used
+func normalizeFrontMatterValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{})
+		for key, nested := range v {
+			result[fmt.Sprintf("%v", key)] = normalizeFrontMatterValue(nested)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{})
+		for key, nested := range v {
+			result[key] = normalizeFrontMatterValue(nested)
+		}
+		return result
+	case []map[string]interface{}:
+		result := []interface{}{}
+		for _, nested := range v {
+			result = append(result, normalizeFrontMatterValue(nested))
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, nested := range v {
+			result = append(result, normalizeFrontMatterValue(nested))
+		}
+		return result
+	}
+	return val
+}
+
+// metadataString returns the string form of a metadata value, as it is
+// stored in Document.Attributes. Lists are joined with commas.
+func metadataString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, metadataString(item))
+		}
+		return strings.Join(items, ", ")
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		items := []string{}
+		for _, key := range keys {
+			items = append(items, fmt.Sprintf("%v: %v", key, metadataString(v[key])))
+		}
+		return strings.Join(items, ", ")
+	case time.Time:
+		return v.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%v", val)
+}
+
+// metadataStrings returns a metadata value as a list of strings. A string
+// value is treated as a comma-separated list.
+func metadataStrings(val interface{}) []string {
+	result := []string{}
+	switch v := val.(type) {
+	case []interface{}:
+		for _, item := range v {
+			s := strings.TrimSpace(metadataString(item))
+			if s != "" {
+				result = append(result, s)
+			}
+		}
+	default:
+		for _, item := range strings.Split(metadataString(val), ",") {
+			s := strings.TrimSpace(item)
+			if s != "" {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// SetFrontMatter stores the parsed front matter in the document, and the
+// string form of each value in the document's attributes
+func (document *Document) SetFrontMatter(frontMatter map[string]interface{}) {
+	document.FrontMatter = frontMatter
+	for key, val := range frontMatter {
+		document.Attributes[key] = metadataString(val)
+	}
+}
+
+// ProcessMetadata builds the document's metadata from its front matter and
+// attributes, and populates the typed fields (title, date, tags, draft,
+// description, slug, aliases, weight and the sitemap attributes) from it.
+// Front matter values keep their types, unless the <attributes> tag
+// overrode them with a string.
+func (document *Document) ProcessMetadata() error {
+	document.Metadata = make(map[string]interface{})
+	for key, val := range document.FrontMatter {
+		document.Metadata[key] = val
+	}
+	for key, val := range document.Attributes {
+		frontMatterVal, ok := document.FrontMatter[key]
+		if !ok || metadataString(frontMatterVal) != val {
+			document.Metadata[key] = val
+		}
+	}
+
+	for key, val := range document.Metadata {
+		switch key {
+		case constants.TitleAttribute:
+			document.Title = metadataString(val)
+		case constants.DescriptionAttribute:
+			document.Description = metadataString(val)
+		case constants.SlugAttribute:
+			document.Slug = strings.Trim(metadataString(val), "/")
+		case constants.TagsAttribute:
+			document.Tags = metadataStrings(val)
+		case constants.AliasesAttribute:
+			document.Aliases = metadataStrings(val)
+		case constants.DateAttribute:
+			date, err := parseDate(val)
+			if err != nil {
+				return fmt.Errorf("invalid %v attribute: %v", constants.DateAttribute, err.Error())
+			}
+			document.Date = date
+		case constants.DraftAttribute:
+			draft, err := metadataBool(val)
+			if err != nil {
+				return fmt.Errorf("invalid %v attribute %v: expected true or false", constants.DraftAttribute, metadataString(val))
+			}
+			document.Draft = draft
+		case constants.SitemapAttribute:
+			listed, err := metadataBool(val)
+			if err != nil {
+				return fmt.Errorf("invalid %v attribute %v: expected true or false", constants.SitemapAttribute, metadataString(val))
+			}
+			document.SitemapExclude = !listed
+		case constants.PriorityAttribute:
+			priority, err := strconv.ParseFloat(strings.TrimSpace(metadataString(val)), 64)
+			if err != nil || priority < 0 || priority > 1 {
+				return fmt.Errorf("invalid %v attribute %v: expected a number from 0.0 to 1.0", constants.PriorityAttribute, metadataString(val))
+			}
+			document.SitemapPriority = strconv.FormatFloat(priority, 'f', -1, 64)
+		case constants.ChangeFreqAttribute:
+			changeFreq := strings.ToLower(strings.TrimSpace(metadataString(val)))
+			valid := false
+			for _, allowed := range constants.SitemapChangeFreqs {
+				if changeFreq == allowed {
+					valid = true
+				}
+			}
+			if !valid {
+				return fmt.Errorf("invalid %v attribute %v: expected one of %v", constants.ChangeFreqAttribute, metadataString(val), strings.Join(constants.SitemapChangeFreqs, ", "))
+			}
+			document.SitemapChangeFreq = changeFreq
+		case constants.WeightAttribute:
+			switch v := val.(type) {
+			case int:
+				document.Weight = v
+			case int64:
+				document.Weight = int(v)
+			default:
+				weight, err := strconv.Atoi(metadataString(val))
+				if err != nil {
+					return fmt.Errorf("invalid %v attribute %v: expected an integer", constants.WeightAttribute, metadataString(val))
+				}
+				document.Weight = weight
+			}
+		}
+	}
+
+	return nil
+}
+
+// metadataBool converts a boolean attribute, which is only a bool if it
+// came from front matter
+func metadataBool(val interface{}) (bool, error) {
+	b, ok := val.(bool)
+	if ok {
+		return b, nil
+	}
+	return strconv.ParseBool(strings.TrimSpace(metadataString(val)))
+}
+
+// parseDate converts a date attribute to a time.Time. TOML dates are
+// already decoded, while YAML dates and <attributes> dates are strings.
+func parseDate(val interface{}) (time.Time, error) {
+	date, ok := val.(time.Time)
+	if ok {
+		return date, nil
+	}
+
+	s := strings.TrimSpace(metadataString(val))
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, s)
+		if err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%v does not match any of the supported formats: %v", s, strings.Join(dateLayouts, ", "))
+}
@@ -0,0 +1,227 @@
+package document
+
+import (
+	"lightsites/config"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName          string
+		InputContent      string
+		ExpectFrontMatter map[string]interface{}
+		ExpectBody        string
+		ExpectError       bool
+	}{
+		{
+			"SplitFrontMatter no front matter",
+			"# Title\n",
+			nil,
+			"# Title\n",
+			false,
+		},
+		{
+			"SplitFrontMatter YAML",
+			"---\ntitle: Hello\ntags: [a, b]\nauthor:\n  name: Jane\n---\n# Title\n",
+			map[string]interface{}{
+				"title":  "Hello",
+				"tags":   []interface{}{"a", "b"},
+				"author": map[string]interface{}{"name": "Jane"},
+			},
+			"# Title\n",
+			false,
+		},
+		{
+			"SplitFrontMatter TOML",
+			"+++\ntitle = \"Hello\"\nweight = 2\n\n[author]\nname = \"Jane\"\n+++\n# Title\n",
+			map[string]interface{}{
+				"title":  "Hello",
+				"weight": int64(2),
+				"author": map[string]interface{}{"name": "Jane"},
+			},
+			"# Title\n",
+			false,
+		},
+		{
+			"SplitFrontMatter unterminated",
+			"---\ntitle: Hello\n# Title\n",
+			nil,
+			"---\ntitle: Hello\n# Title\n",
+			true,
+		},
+		{
+			"SplitFrontMatter invalid YAML",
+			"---\ntitle: [Hello\n---\n# Title\n",
+			nil,
+			"---\ntitle: [Hello\n---\n# Title\n",
+			true,
+		},
+		{
+			"SplitFrontMatter invalid TOML",
+			"+++\ntitle = \n+++\n# Title\n",
+			nil,
+			"+++\ntitle = \n+++\n# Title\n",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		frontMatter, body, err := SplitFrontMatter([]byte(test.InputContent))
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+		} else {
+			assert.NoError(err, test.TestName)
+		}
+		assert.Equal(test.ExpectFrontMatter, frontMatter, test.TestName)
+		assert.Equal(test.ExpectBody, string(body), test.TestName)
+	}
+}
+
+func TestProcessMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName       string
+		InputDocument  Document
+		ExpectDocument Document
+		ExpectError    bool
+	}{
+		{
+			"ProcessMetadata attributes tag only",
+			Document{
+				Attributes: map[string]string{"title": "Hello", "tags": "a, b", "draft": "true", "weight": "2", "date": "2020-10-01"},
+			},
+			Document{
+				Title:      "Hello",
+				Tags:       []string{"a", "b"},
+				Draft:      true,
+				Weight:     2,
+				Date:       time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC),
+				Attributes: map[string]string{"title": "Hello", "tags": "a, b", "draft": "true", "weight": "2", "date": "2020-10-01"},
+				Metadata:   map[string]interface{}{"title": "Hello", "tags": "a, b", "draft": "true", "weight": "2", "date": "2020-10-01"},
+			},
+			false,
+		},
+		{
+			"ProcessMetadata attributes tag overrides front matter",
+			Document{
+				Attributes:  map[string]string{"title": "Tag", "aliases": "a, b"},
+				FrontMatter: map[string]interface{}{"title": "Front Matter", "aliases": []interface{}{"a", "b"}},
+			},
+			Document{
+				Title:       "Tag",
+				Aliases:     []string{"a", "b"},
+				Attributes:  map[string]string{"title": "Tag", "aliases": "a, b"},
+				FrontMatter: map[string]interface{}{"title": "Front Matter", "aliases": []interface{}{"a", "b"}},
+				Metadata:    map[string]interface{}{"title": "Tag", "aliases": []interface{}{"a", "b"}},
+			},
+			false,
+		},
+		{
+			"ProcessMetadata sitemap attributes",
+			Document{
+				Attributes:  map[string]string{"sitemap": "false", "priority": "0.8", "changefreq": "Weekly"},
+				FrontMatter: map[string]interface{}{"sitemap": false, "priority": 0.8},
+			},
+			Document{
+				SitemapExclude:    true,
+				SitemapPriority:   "0.8",
+				SitemapChangeFreq: "weekly",
+				Attributes:        map[string]string{"sitemap": "false", "priority": "0.8", "changefreq": "Weekly"},
+				FrontMatter:       map[string]interface{}{"sitemap": false, "priority": 0.8},
+				Metadata:          map[string]interface{}{"sitemap": false, "priority": 0.8, "changefreq": "Weekly"},
+			},
+			false,
+		},
+		{
+			"ProcessMetadata invalid priority",
+			Document{Attributes: map[string]string{"priority": "2"}},
+			Document{},
+			true,
+		},
+		{
+			"ProcessMetadata invalid changefreq",
+			Document{Attributes: map[string]string{"changefreq": "sometimes"}},
+			Document{},
+			true,
+		},
+		{
+			"ProcessMetadata invalid date",
+			Document{Attributes: map[string]string{"date": "yesterday"}},
+			Document{},
+			true,
+		},
+		{
+			"ProcessMetadata invalid draft",
+			Document{Attributes: map[string]string{"draft": "maybe"}},
+			Document{},
+			true,
+		},
+		{
+			"ProcessMetadata invalid weight",
+			Document{Attributes: map[string]string{"weight": "heavy"}},
+			Document{},
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.InputDocument.ProcessMetadata()
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+			continue
+		}
+		assert.NoError(err, test.TestName)
+		assert.Equal(test.ExpectDocument, test.InputDocument, test.TestName)
+	}
+}
+
+func TestParseDocumentFrontMatter(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defaultConfig := config.GetDefaultConfig()
+	defaultConfig.Directories.Templates = "../tests/templates"
+	defaultConfig.Directories.Documents = "../tests/frontmatter-docs"
+	documentDirectory := []string{"toml", "yaml"}
+	documents := []Document{}
+
+	for _, file := range documentDirectory {
+		_, err := ParseDocument(&defaultConfig, &documents, &documentDirectory, nil, file)
+		require.NoError(err, file)
+	}
+	require.Len(documents, 2)
+
+	tomlDoc := documents[0]
+	assert.Equal("TOML Document", tomlDoc.Title)
+	assert.Equal(time.Date(2020, 10, 2, 10, 0, 0, 0, time.UTC), tomlDoc.Date.UTC())
+	assert.Equal([]string{"go"}, tomlDoc.Tags)
+	assert.Equal(5, tomlDoc.Weight)
+	assert.Equal("Overridden by the attributes tag", tomlDoc.Description)
+	assert.Contains(tomlDoc.FileContents, "<title>TOML Document</title>")
+	assert.NotContains(tomlDoc.FileContents, "+++")
+
+	yamlDoc := documents[1]
+	assert.Equal("YAML Document", yamlDoc.Title)
+	assert.Equal(time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC), yamlDoc.Date)
+	assert.Equal([]string{"go", "markdown"}, yamlDoc.Tags)
+	assert.True(yamlDoc.Draft)
+	assert.Equal("A document with YAML front matter", yamlDoc.Description)
+	assert.Equal("yaml-doc", yamlDoc.Slug)
+	assert.Equal([]string{"old-yaml"}, yamlDoc.Aliases)
+	assert.Equal(3, yamlDoc.Weight)
+	assert.Equal(
+		map[string]interface{}{"name": "Jane", "links": []interface{}{"a", "b"}},
+		yamlDoc.Metadata["author"],
+	)
+	assert.Equal("go, markdown", yamlDoc.Attributes["tags"])
+	assert.Contains(yamlDoc.FileContents, "<title>YAML Document</title>")
+	assert.NotContains(yamlDoc.FileContents, "description:")
+}
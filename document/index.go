@@ -0,0 +1,289 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Heading is a heading of a document, with the ID it can be linked to with
+type Heading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+// IndexEntry holds the metadata of a document that other documents can
+// use while they are rendered, such as the titles in a directory listing
+type IndexEntry struct {
+	FileName string
+	// URL is the path the document is served at
+	URL         string
+	Title       string
+	Description string
+	Date        time.Time
+	Weight      int
+	Draft       bool
+	Tags        []string
+	// Headings lists the headings of the document's markdown, in order.
+	// Headings added by templates aren't included.
+	Headings []Heading
+	// Summary is the text of the document's first paragraph, shortened to
+	// about constants.SummaryLength characters
+	Summary string
+	// Links lists the documents that the document links to, sorted by
+	// file name
+	Links []string
+}
+
+// Index holds the metadata of every document in the site, keyed by file
+// name relative to the documents directory
+type Index map[string]IndexEntry
+
+// ReadIndexEntry reads the metadata of a document, from its front matter
+// and <attributes> tag, along with its headings, summary and links to
+// other documents. This is the first of the two passes over the documents,
+// so templates aren't rendered. A document without a title is indexed with
+// an empty title; it fails once it is rendered.
+func ReadIndexEntry(conf *config.Config, fileName string) (entry IndexEntry, err error) {
+	entry.FileName = fileName
+	entry.URL = fmt.Sprintf("%v%v%v", conf.Routing.RoutePrefix, fileName, conf.Routing.UrlFileSuffix)
+
+	content, err := ioutil.ReadFile(fmt.Sprintf("%v/%v%v", conf.Directories.Documents, fileName, constants.MarkdownFileSuffix))
+	if err != nil {
+		return entry, fmt.Errorf("failed to read file %v: %v", fileName, err.Error())
+	}
+	content = []byte(strings.TrimLeft(string(content), "\n"))
+
+	frontMatter, content, err := SplitFrontMatter(content)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read front matter for file %v: %v", fileName, err.Error())
+	}
+
+	doc := Document{
+		FileName:   fileName,
+		Attributes: make(map[string]string),
+		Config:     conf,
+	}
+	doc.SetFrontMatter(frontMatter)
+
+	htmlDoc, err := html.Parse(strings.NewReader(string(renderMarkdown(content))))
+	if err != nil {
+		return entry, fmt.Errorf("failed to parse html for file %v: %v", fileName, err.Error())
+	}
+	// a missing title is only an error once the document is rendered
+	_ = doc.ProcessAttributes(htmlDoc)
+	err = doc.ProcessMetadata()
+	if err != nil {
+		return entry, fmt.Errorf("failed to read metadata for file %v: %v", fileName, err.Error())
+	}
+
+	entry.Title = doc.Title
+	entry.Description = doc.Description
+	entry.Date = doc.Date
+	entry.Weight = doc.Weight
+	entry.Draft = doc.Draft
+	entry.Tags = doc.Tags
+
+	body := helpers.GetNodeOfType(htmlDoc, constants.BodyNode)
+	if body != nil {
+		entry.Headings = headings(body)
+		entry.Summary = summary(body)
+		entry.Links = links(fileName, body)
+	}
+
+	return entry, nil
+}
+
+// headings returns every heading below n that has an ID
+func headings(n *html.Node) []Heading {
+	result := []Heading{}
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6' {
+			id := getAttribute(n, "id")
+			if id != "" {
+				result = append(result, Heading{Level: int(n.Data[1] - '0'), ID: id, Text: nodeText(n)})
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return result
+}
+
+// summary returns the text of the first paragraph below n that has any,
+// shortened at a word boundary
+func summary(n *html.Node) string {
+	var result string
+	var f func(*html.Node) bool
+	f = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.Data == constants.ParagraphNode {
+			result = nodeText(n)
+			return result != ""
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if f(c) {
+				return true
+			}
+		}
+		return false
+	}
+	f(n)
+
+	runes := []rune(result)
+	if len(runes) <= constants.SummaryLength {
+		return result
+	}
+	shortened := string(runes[:constants.SummaryLength])
+	space := strings.LastIndex(shortened, " ")
+	if space > 0 {
+		shortened = shortened[:space]
+	}
+	return strings.TrimRight(shortened, " ,.;:") + "…"
+}
+
+// nodeText returns the text below n, with whitespace collapsed
+func nodeText(n *html.Node) string {
+	var buf strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return strings.Join(strings.Fields(buf.String()), " ")
+}
+
+// links returns the documents that the links below n point to, sorted by
+// file name
+func links(fileName string, n *html.Node) []string {
+	found := make(map[string]bool)
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			target, _, ok := documentLink(fileName, getAttribute(n, constants.HrefAttribute))
+			if ok && target != fileName {
+				found[target] = true
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+
+	result := []string{}
+	for target := range found {
+		result = append(result, target)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// documentLink resolves a link to the markdown source of another document,
+// such as other.md, ../blog/post.md#intro or /blog/post.md, to the name of
+// the document and the fragment of the link. Links that aren't relative
+// links to a .md file are not document links.
+func documentLink(fileName string, href string) (target string, fragment string, ok bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" || !strings.HasSuffix(u.Path, constants.MarkdownFileSuffix) {
+		return "", "", false
+	}
+
+	linked := u.Path
+	if !strings.HasPrefix(linked, "/") {
+		linked = path.Join(path.Dir(fileName), linked)
+	}
+	linked = strings.TrimPrefix(path.Clean("/"+linked), "/")
+	return strings.TrimSuffix(linked, constants.MarkdownFileSuffix), u.Fragment, true
+}
+
+// ProcessDocumentLinks points links to the markdown files of other
+// documents, such as [post](../blog/post.md#intro), at the routes the
+// documents are served at. Links to documents that don't exist are left as
+// they are.
+func (document *Document) ProcessDocumentLinks(n *html.Node) {
+	if n.Type == html.ElementNode && n.Data == "a" {
+		target, fragment, ok := documentLink(document.FileName, getAttribute(n, constants.HrefAttribute))
+		if ok {
+			// whether or not the link resolves depends on the index
+			document.UsesIndex = true
+		}
+		entry, exists := document.Index[target]
+		if ok && exists {
+			href := entry.URL
+			if fragment != "" {
+				href += "#" + fragment
+			}
+			// the markdown renderer opens links to other documents in a new
+			// tab unless they start with a "/", which they shouldn't be
+			attrs := []html.Attribute{}
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case constants.HrefAttribute:
+					attr.Val = href
+				case constants.TargetAttribute:
+					continue
+				}
+				attrs = append(attrs, attr)
+			}
+			n.Attr = attrs
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		document.ProcessDocumentLinks(c)
+	}
+}
+
+// Entries returns every entry of the index, sorted by file name
+func (index Index) Entries() []IndexEntry {
+	entries := []IndexEntry{}
+	for _, entry := range index {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FileName < entries[j].FileName })
+	return entries
+}
+
+// Backlinks returns the entries of the documents that link to the document,
+// sorted by file name
+func (index Index) Backlinks(fileName string) []IndexEntry {
+	backlinks := []IndexEntry{}
+	for _, entry := range index.Entries() {
+		for _, link := range entry.Links {
+			if link == fileName {
+				backlinks = append(backlinks, entry)
+				break
+			}
+		}
+	}
+	return backlinks
+}
+
+// Lookup returns the index entry of a document, or an entry with just the
+// file name if the document isn't indexed
+func (index Index) Lookup(fileName string) IndexEntry {
+	entry, ok := index[fileName]
+	if !ok {
+		return IndexEntry{FileName: fileName}
+	}
+	return entry
+}
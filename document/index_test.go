@@ -0,0 +1,137 @@
+package document
+
+import (
+	"lightsites/config"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTestIndex builds the index of the documents in tests/index-docs
+func readTestIndex(t *testing.T, conf *config.Config, files []string) Index {
+	index := Index{}
+	for _, file := range files {
+		entry, err := ReadIndexEntry(conf, file)
+		require.NoError(t, err, file)
+		index[file] = entry
+	}
+	return index
+}
+
+func TestReadIndexEntryMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/index-docs"
+	conf.Routing.RoutePrefix = "/"
+	index := readTestIndex(t, &conf, []string{"blog/post", "guide", "index"})
+
+	guide := index["guide"]
+	assert.Equal("/guide.html", guide.URL)
+	assert.Equal("Guide", guide.Title)
+	assert.Equal("How to set up a site", guide.Description)
+	assert.Equal([]string{"docs"}, guide.Tags)
+	assert.Equal([]Heading{
+		{Level: 1, ID: "guide", Text: "Guide"},
+		{Level: 2, ID: "setup", Text: "Setup"},
+		{Level: 2, ID: "how-to", Text: "Usage"},
+	}, guide.Headings)
+	assert.Equal("Lightsites renders a directory of markdown documents into a website, and serves it with nothing more than a single binary and a configuration file. This paragraph is long enough that it has to be…", guide.Summary)
+	assert.Equal([]string{"index"}, guide.Links)
+
+	post := index["blog/post"]
+	assert.Equal(time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC), post.Date)
+	assert.Equal([]Heading{}, post.Headings)
+	assert.Equal("A post that links to the guide twice, see the guide.", post.Summary)
+	assert.Equal([]string{"guide"}, post.Links)
+
+	assert.Equal([]string{"blog/post", "guide", "missing"}, index["index"].Links)
+
+	var names []string
+	for _, entry := range index.Entries() {
+		names = append(names, entry.FileName)
+	}
+	assert.Equal([]string{"blog/post", "guide", "index"}, names)
+
+	var backlinks []string
+	for _, entry := range index.Backlinks("guide") {
+		backlinks = append(backlinks, entry.FileName)
+	}
+	assert.Equal([]string{"blog/post", "index"}, backlinks)
+	assert.Equal([]IndexEntry{}, index.Backlinks("blog"))
+}
+
+func TestDocumentLink(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName         string
+		InputFileName    string
+		InputHref        string
+		ExpectedTarget   string
+		ExpectedFragment string
+		ExpectedOK       bool
+	}{
+		{"DocumentLink same directory", "blog/post", "other.md", "blog/other", "", true},
+		{"DocumentLink parent directory", "blog/post", "../guide.md#setup", "guide", "setup", true},
+		{"DocumentLink from the documents root", "blog/post", "/blog/2020/old.md", "blog/2020/old", "", true},
+		{"DocumentLink can't leave the documents root", "post", "../../secret.md", "secret", "", true},
+		{"DocumentLink not markdown", "post", "guide.html", "", "", false},
+		{"DocumentLink fragment only", "post", "#setup", "", "", false},
+		{"DocumentLink absolute URL", "post", "https://example.com/guide.md", "", "", false},
+		{"DocumentLink query", "post", "guide.md?raw=true", "", "", false},
+	}
+
+	for _, test := range tests {
+		target, fragment, ok := documentLink(test.InputFileName, test.InputHref)
+		assert.Equal(test.ExpectedTarget, target, test.TestName)
+		assert.Equal(test.ExpectedFragment, fragment, test.TestName)
+		assert.Equal(test.ExpectedOK, ok, test.TestName)
+	}
+}
+
+// TestIndexRendering validates that documents are rendered with the index
+// of every document available, so that links to other documents' markdown
+// files resolve and templates can read other documents' metadata
+func TestIndexRendering(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/index-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Routing.RoutePrefix = "/"
+	files := []string{"blog/post", "guide", "index"}
+	index := readTestIndex(t, &conf, files)
+
+	doc, err := NewDocument(&conf, &files, index, "index")
+	require.NoError(err)
+	assert.True(doc.UsesIndex)
+	assert.True(doc.DependsOnIndex())
+	assert.Contains(doc.FileContents, `<a href="/guide.html#setup" rel="noreferrer noopener">guide</a>`)
+	assert.Contains(doc.FileContents, `<a href="/blog/post.html">latest post</a>`)
+	assert.Contains(doc.FileContents, `<a href="missing.md" target="_blank" rel="noreferrer noopener">missing page</a>`)
+	assert.Contains(doc.FileContents, `<p class="related"><a href="/blog/post.html">Post</a></p>`)
+	assert.Contains(doc.FileContents, `<ul class="backlinks"><li><a href="/guide.html">Guide</a></li></ul>`)
+
+	doc, err = NewDocument(&conf, &files, index, "blog/post")
+	require.NoError(err)
+	assert.Contains(doc.FileContents, `<a href="/guide.html">guide</a>`)
+	assert.Contains(doc.FileContents, `<a href="/guide.html#how-to">guide</a>`)
+
+	// a document that neither links to other documents nor uses
+	// html/template templates doesn't depend on the index
+	noLinks := Document{Config: &conf, FileName: "plain", Attributes: make(map[string]string), Index: index}
+	_, err = noLinks.ProcessHTMLTree(`<html><head></head><body><attributes title="Plain"></attributes><p><a href="https://example.com/">Out</a></p></body></html>`)
+	require.NoError(err)
+	assert.False(noLinks.DependsOnIndex())
+
+	// templates fail on documents that don't exist
+	missing := Document{Config: &conf, FileName: "plain", Attributes: make(map[string]string), Index: index}
+	_, err = missing.ProcessHTMLTree(`<html><head></head><body><attributes title="Plain"></attributes><template file="related.gohtml" document="nope"></template></body></html>`)
+	require.Error(err)
+	assert.Contains(err.Error(), "document nope does not exist")
+}
@@ -0,0 +1,209 @@
+package document
+
+import (
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Layout returns the layout template of the document, relative to the
+// templates directory. The layout attribute takes precedence over the
+// configured layouts, and a layout of "none" opts out of them. An empty
+// layout means the body is wrapped in the bodyConfig grid instead.
+func (document *Document) Layout() string {
+	layout := ""
+	val, ok := document.Metadata[constants.LayoutAttribute]
+	if ok {
+		layout = strings.TrimSpace(metadataString(val))
+	}
+	switch layout {
+	case constants.LayoutNone:
+		return ""
+	case "":
+		return document.Config.GetLayout(document.FileName)
+	}
+	return layout
+}
+
+// isDocumentRegion returns true if the document can fill the region. The
+// main region always holds the rendered markdown.
+func isDocumentRegion(region string) bool {
+	for _, known := range constants.LayoutRegions {
+		if region == known && region != constants.RegionMain {
+			return true
+		}
+	}
+	return false
+}
+
+// documentRegionNames lists the regions a document can fill, for errors
+func documentRegionNames() string {
+	names := []string{}
+	for _, region := range constants.LayoutRegions {
+		if isDocumentRegion(region) {
+			names = append(names, region)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// templateRegion returns the layout region that a <template> tag sends its
+// output to, if any. heading="true" is the same as region="header".
+func templateRegion(attributes map[string]string) (string, error) {
+	region, ok := attributes[constants.TemplateRegionKey]
+	if ok {
+		if !isDocumentRegion(region) {
+			return "", fmt.Errorf("unknown region %v, expected one of: %v", region, documentRegionNames())
+		}
+		return region, nil
+	}
+
+	isHeading, err := strconv.ParseBool(attributes[constants.TemplateHeadingKey])
+	if err == nil && isHeading {
+		return constants.RegionHeader, nil
+	}
+	return "", nil
+}
+
+// moveToRegion replaces n with a <region> element holding the children of
+// container. Since a region holds block elements, it is placed before the
+// paragraph that markdown wrapped around n, if any.
+func moveToRegion(n *html.Node, container *html.Node, region string) {
+	regionNode := &html.Node{
+		Type: html.ElementNode,
+		Data: constants.RegionNode,
+		Attr: []html.Attribute{{Key: constants.SlotNameKey, Val: region}},
+	}
+	moveChildren(container, regionNode, nil)
+
+	parent, before := n.Parent, n
+	if parent.Data == constants.ParagraphNode && parent.Parent != nil {
+		parent, before = parent.Parent, parent
+	}
+	parent.InsertBefore(regionNode, before)
+	removeNode(n)
+}
+
+// removeNode removes n from its parent, along with the paragraph that
+// markdown wrapped around it, if n was all the paragraph held
+func removeNode(n *html.Node) {
+	parent := n.Parent
+	parent.RemoveChild(n)
+	if parent.Data == constants.ParagraphNode && parent.Parent != nil && len(childNodes(parent)) == 0 {
+		parent.Parent.RemoveChild(parent)
+	}
+}
+
+// collectRegions removes every <region name="..."> element from the body,
+// and returns their children by region, in document order
+func collectRegions(body *html.Node) (map[string][]*html.Node, error) {
+	regions := make(map[string][]*html.Node)
+
+	regionNodes := []*html.Node{}
+	var f func(*html.Node)
+	f = func(parent *html.Node) {
+		for c := parent.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.Data == constants.RegionNode {
+				regionNodes = append(regionNodes, c)
+				continue
+			}
+			f(c)
+		}
+	}
+	f(body)
+
+	for _, regionNode := range regionNodes {
+		region := getAttribute(regionNode, constants.SlotNameKey)
+		if !isDocumentRegion(region) {
+			return regions, fmt.Errorf("<%v> elements must have a %v attribute of one of: %v", constants.RegionNode, constants.SlotNameKey, documentRegionNames())
+		}
+		regions[region] = append(regions[region], childNodes(regionNode)...)
+		for _, c := range childNodes(regionNode) {
+			regionNode.RemoveChild(c)
+		}
+		removeNode(regionNode)
+	}
+
+	return regions, nil
+}
+
+// ProcessLayout applies the document's layout to the <body> of htmlstr, if
+// it has one, and returns the re-rendered document
+func (document *Document) ProcessLayout(htmlstr string) (string, error) {
+	layout := document.Layout()
+	if layout == "" {
+		return htmlstr, nil
+	}
+
+	doc, err := html.Parse(strings.NewReader(htmlstr))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse html: %v", err.Error())
+	}
+	body := helpers.GetNodeOfType(doc, constants.BodyNode)
+	if body == nil {
+		return "", fmt.Errorf("document has no %v node", constants.BodyNode)
+	}
+
+	regions, err := collectRegions(body)
+	if err != nil {
+		return "", err
+	}
+	err = document.applyLayout(body, layout, regions)
+	if err != nil {
+		return "", err
+	}
+
+	return helpers.RenderNode(doc)
+}
+
+// applyLayout replaces the children of the body with the document's layout
+// template. The layout is rendered like any other template, with the
+// document's attributes as its variables and the regions as its slots: the
+// remaining children of the body fill the main region (and {{content}}),
+// and the collected regions fill the others. Regions the document doesn't
+// fill are empty.
+func (document *Document) applyLayout(body *html.Node, layout string, regions map[string][]*html.Node) error {
+	slots := make(map[string]string)
+	for _, region := range constants.LayoutRegions {
+		nodes := regions[region]
+		if region == constants.RegionMain {
+			nodes = childNodes(body)
+		}
+		rendered, err := renderNodes(nodes)
+		if err != nil {
+			return fmt.Errorf("failed to render the %v region: %v", region, err.Error())
+		}
+		slots[region] = rendered
+	}
+	slots[constants.DefaultSlot] = slots[constants.RegionMain]
+
+	attributes := make(map[string]string)
+	for key, val := range document.Attributes {
+		attributes[key] = val
+	}
+
+	root := body
+	for root.Parent != nil {
+		root = root.Parent
+	}
+
+	container, err := document.expandTemplate(layout, attributes, slots, root, []string{})
+	if err != nil {
+		return fmt.Errorf("failed to apply layout %v: %v", layout, err.Error())
+	}
+
+	for c := body.FirstChild; c != nil; c = body.FirstChild {
+		body.RemoveChild(c)
+	}
+	moveChildren(container, body, nil)
+
+	return nil
+}
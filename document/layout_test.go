@@ -0,0 +1,209 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func TestLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName       string
+		InputFileName  string
+		InputMetadata  map[string]interface{}
+		InputLayouts   config.LayoutsConfig
+		ExpectedLayout string
+	}{
+		{"Layout none configured", "index", nil, config.LayoutsConfig{}, ""},
+		{"Layout default", "index", nil, config.LayoutsConfig{Default: "base.html"}, "base.html"},
+		{
+			"Layout directory",
+			"blog/post",
+			nil,
+			config.LayoutsConfig{Default: "base.html", Directories: map[string]string{"blog": "blog.html", "docs": "docs.html"}},
+			"blog.html",
+		},
+		{
+			"Layout longest directory",
+			"blog/drafts/post",
+			nil,
+			config.LayoutsConfig{Default: "base.html", Directories: map[string]string{"blog/": "blog.html", "blog/drafts": "drafts.html"}},
+			"drafts.html",
+		},
+		{
+			"Layout directory is not a file name prefix",
+			"blogroll",
+			nil,
+			config.LayoutsConfig{Default: "base.html", Directories: map[string]string{"blog": "blog.html"}},
+			"base.html",
+		},
+		{
+			"Layout attribute",
+			"blog/post",
+			map[string]interface{}{constants.LayoutAttribute: "wide.html"},
+			config.LayoutsConfig{Default: "base.html", Directories: map[string]string{"blog": "blog.html"}},
+			"wide.html",
+		},
+		{
+			"Layout attribute none",
+			"index",
+			map[string]interface{}{constants.LayoutAttribute: constants.LayoutNone},
+			config.LayoutsConfig{Default: "base.html"},
+			"",
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Layouts = test.InputLayouts
+		document := Document{Config: &conf, FileName: test.InputFileName, Metadata: test.InputMetadata}
+		assert.Equal(test.ExpectedLayout, document.Layout(), test.TestName)
+	}
+}
+
+// TestApplyLayout validates that documents are wrapped in their layout,
+// with their regions filled, and that heading templates end up outside of
+// the bodyConfig grid when there is no layout
+func TestApplyLayout(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName    string
+		InputLayout string
+		InputBody   string
+		ExpectHead  string
+		ExpectBody  string
+		ExpectError string
+	}{
+		{
+			"ApplyLayout regions",
+			"layouts/base.html",
+			`<attributes title="Doc"></attributes><p>Text</p><template file="alert.html" heading="true" alert-text="Hi"></template><region name="sidebar"><p>Side</p></region>`,
+			`<meta name="generator" content="lightsites"/>`,
+			`<header><nav><a href="/">Home</a></nav><div class="alert alert-primary">Hi</div></header>` + "\n" +
+				`<main class="container"><p>Text</p></main>` + "\n" +
+				`<aside><p>Side</p></aside>` + "\n" +
+				`<footer><span>Doc</span></footer>`,
+			"",
+		},
+		{
+			"ApplyLayout template region",
+			"layouts/base.html",
+			`<attributes title="Doc"></attributes><p>Text</p><p><template file="alert.html" region="footer" alert-text="Bye"></template></p>`,
+			`<meta name="generator" content="lightsites"/>`,
+			`<header><nav><a href="/">Home</a></nav></header>` + "\n" +
+				`<main class="container"><p>Text</p></main>` + "\n" +
+				`<aside></aside>` + "\n" +
+				`<footer><div class="alert alert-primary">Bye</div><span>Doc</span></footer>`,
+			"",
+		},
+		{
+			"ApplyLayout html/template",
+			"layouts/plain.gohtml",
+			`<attributes title="Doc"></attributes><p>Text</p><region name="sidebar">Side</region>`,
+			``,
+			`<main><p>Text</p></main>` + "\n" + `<aside>Side</aside>`,
+			"",
+		},
+		{
+			"ApplyLayout no layout puts the heading above the grid",
+			"",
+			`<attributes title="Doc"></attributes><p>Text</p><template file="alert.html" heading="true" alert-text="Hi"></template>`,
+			``,
+			`<div class="alert alert-primary">Hi</div><div class="container"><div class="row"><div class="col-lg-12"><p>Text</p></div></div></div>`,
+			"",
+		},
+		{
+			"ApplyLayout missing layout",
+			"layouts/missing.html",
+			`<attributes title="Doc"></attributes><p>Text</p>`,
+			"",
+			"",
+			"failed to apply layout layouts/missing.html: failed to read template file layouts/missing.html",
+		},
+		{
+			"ApplyLayout unknown region",
+			"layouts/base.html",
+			`<attributes title="Doc"></attributes><region name="main">Text</region>`,
+			"",
+			"",
+			"<region> elements must have a name attribute of one of: header, sidebar, footer",
+		},
+		{
+			"ApplyLayout unknown template region",
+			"layouts/base.html",
+			`<attributes title="Doc"></attributes><template file="alert.html" region="nav" alert-text="Hi"></template>`,
+			"",
+			"",
+			"invalid use of template alert.html: unknown region nav, expected one of: header, sidebar, footer",
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		conf.CSSImports = []string{}
+		conf.Layouts.Default = test.InputLayout
+		document := Document{Config: &conf, FileName: "index", Attributes: make(map[string]string)}
+
+		output, err := document.ProcessHTMLTree("<html><head></head><body>" + test.InputBody + "</body></html>")
+		if test.ExpectError != "" {
+			require.Error(err, test.TestName)
+			assert.Contains(err.Error(), test.ExpectError, test.TestName)
+			continue
+		}
+		require.NoError(err, test.TestName)
+		if test.InputLayout != "" {
+			assert.True(document.DependsOnTemplate(test.InputLayout), test.TestName)
+		}
+
+		doc, err := html.Parse(strings.NewReader(output))
+		require.NoError(err, test.TestName)
+		head, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.HeadNode))
+		require.NoError(err, test.TestName)
+		assert.Equal("<head>"+test.ExpectHead+"<title>Doc</title></head>", head, test.TestName)
+		body, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+		require.NoError(err, test.TestName)
+		assert.Equal("<body>"+test.ExpectBody+"</body>", body, test.TestName)
+	}
+}
+
+// TestLayoutDirectoryAndTable validates that <directory> and <table> tags in
+// a layout are processed like the ones in the document
+func TestLayoutDirectoryAndTable(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.CSSImports = []string{}
+	conf.Layouts.Default = "layouts/listing.html"
+	document := Document{
+		Config:            &conf,
+		FileName:          "index",
+		Attributes:        make(map[string]string),
+		DocumentDirectory: &[]string{"test1", "test2"},
+	}
+
+	output, err := document.ProcessHTMLTree(`<html><head></head><body><attributes title="Doc"></attributes><p>Text</p></body></html>`)
+	require.NoError(err)
+	assert.True(document.UsesDirectory)
+
+	doc, err := html.Parse(strings.NewReader(output))
+	require.NoError(err)
+	body, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+	require.NoError(err)
+	assert.Equal(`<body><nav><ul><li><a href="/content/test1.html" rel="noopener noreferrer">test1</a></li><li><a href="/content/test2.html" rel="noopener noreferrer">test2</a></li></ul></nav>`+"\n"+
+		`<main><p>Text</p></main>`+"\n"+
+		`<footer><div class="table-responsive"><table class="table table-bordered table-striped table-hover table-sm"><tbody><tr><td>Doc</td></tr></tbody></table></div></footer></body>`, body)
+}
@@ -0,0 +1,290 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TemplateParam is a parameter declared by a template file, which a
+// <template> tag passes as an attribute
+type TemplateParam struct {
+	Name        string
+	Description string
+	Type        string
+	Required    bool
+	Default     string
+	HasDefault  bool
+	Allowed     []string
+}
+
+// TemplateInfo describes a template file and the parameters it declares
+type TemplateInfo struct {
+	File   string
+	Params []TemplateParam
+	Error  string
+}
+
+// String describes the parameter for the templates command, such as
+// `level (string, default "primary", one of: primary, warning)`
+func (p TemplateParam) String() string {
+	details := []string{p.Type}
+	if p.Required {
+		details = append(details, "required")
+	}
+	if p.HasDefault {
+		details = append(details, fmt.Sprintf("default %q", p.Default))
+	}
+	if len(p.Allowed) > 0 {
+		details = append(details, fmt.Sprintf("one of: %v", strings.Join(p.Allowed, ", ")))
+	}
+	result := fmt.Sprintf("%v (%v)", p.Name, strings.Join(details, ", "))
+	if p.Description != "" {
+		result = fmt.Sprintf("%v: %v", result, p.Description)
+	}
+	return result
+}
+
+// validate checks that value is of the parameter's type and one of its
+// allowed values
+func (p TemplateParam) validate(value string) error {
+	switch p.Type {
+	case constants.TemplateParamTypeBool:
+		_, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("parameter %v has value %q, expected true or false", p.Name, value)
+		}
+	case constants.TemplateParamTypeInt:
+		_, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parameter %v has value %q, expected an integer", p.Name, value)
+		}
+	case constants.TemplateParamTypeURL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("parameter %v has value %q, expected a URL: %v", p.Name, value, err.Error())
+		}
+		if u.Scheme != "" && !safeURLSchemes[strings.ToLower(u.Scheme)] {
+			return fmt.Errorf("parameter %v has value %q, expected a relative URL or one of the schemes: %v", p.Name, value, strings.Join(sortedSchemes(), ", "))
+		}
+	}
+
+	if len(p.Allowed) == 0 {
+		return nil
+	}
+	for _, allowed := range p.Allowed {
+		if value == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("parameter %v has value %q, expected one of: %v", p.Name, value, strings.Join(p.Allowed, ", "))
+}
+
+// sortedSchemes returns the safe URL schemes in a stable order for errors
+func sortedSchemes() []string {
+	schemes := []string{}
+	for scheme := range safeURLSchemes {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// SplitTemplateParams separates the parameter declarations from the start
+// of a template file. They are declared in a YAML (`---`) or TOML (`+++`)
+// front matter block, the same way as a document's front matter, as a list
+// under the params key:
+//
+//	---
+//	params:
+//	  - name: level
+//	    default: primary
+//	    allowed: [primary, warning, danger]
+//	  - name: text
+//	    required: true
+//	---
+//
+// A template without a front matter block declares no parameters.
+func SplitTemplateParams(content []byte) (params []TemplateParam, body []byte, err error) {
+	frontMatter, body, err := SplitFrontMatter(content)
+	if err != nil {
+		return nil, content, err
+	}
+	params = []TemplateParam{}
+
+	declarations, ok := frontMatter[constants.TemplateParamsKey]
+	if !ok {
+		return params, body, nil
+	}
+	list, ok := declarations.([]interface{})
+	if !ok {
+		return nil, content, fmt.Errorf("%v must be a list of parameters", constants.TemplateParamsKey)
+	}
+
+	names := make(map[string]bool)
+	for i, declaration := range list {
+		fields, ok := declaration.(map[string]interface{})
+		if !ok {
+			return nil, content, fmt.Errorf("parameter %v must have a name and options", i+1)
+		}
+
+		param, err := parseTemplateParam(fields)
+		if err != nil {
+			return nil, content, err
+		}
+		if names[param.Name] {
+			return nil, content, fmt.Errorf("parameter %v is declared more than once", param.Name)
+		}
+		names[param.Name] = true
+		params = append(params, param)
+	}
+
+	return params, body, nil
+}
+
+// parseTemplateParam builds a parameter from its declared options, and
+// checks that the default, if any, is valid for the parameter
+func parseTemplateParam(fields map[string]interface{}) (param TemplateParam, err error) {
+	name, ok := fields[constants.TemplateParamNameKey]
+	if !ok || strings.TrimSpace(metadataString(name)) == "" {
+		return param, fmt.Errorf("parameter is missing its %v", constants.TemplateParamNameKey)
+	}
+	param.Name = strings.TrimSpace(metadataString(name))
+	param.Type = constants.TemplateParamTypeString
+
+	for key, val := range fields {
+		switch key {
+		case constants.TemplateParamNameKey:
+		case constants.TemplateParamDescriptionKey:
+			param.Description = metadataString(val)
+		case constants.TemplateParamTypeKey:
+			param.Type = strings.ToLower(strings.TrimSpace(metadataString(val)))
+		case constants.TemplateParamRequiredKey:
+			switch v := val.(type) {
+			case bool:
+				param.Required = v
+			default:
+				param.Required, err = strconv.ParseBool(metadataString(val))
+				if err != nil {
+					return param, fmt.Errorf("parameter %v has invalid %v option %v: expected true or false", param.Name, key, metadataString(val))
+				}
+			}
+		case constants.TemplateParamDefaultKey:
+			param.Default = metadataString(val)
+			param.HasDefault = true
+		case constants.TemplateParamAllowedKey:
+			switch v := val.(type) {
+			case []interface{}:
+				for _, item := range v {
+					param.Allowed = append(param.Allowed, metadataString(item))
+				}
+			default:
+				param.Allowed = metadataStrings(val)
+			}
+		default:
+			return param, fmt.Errorf("parameter %v has unknown option %v", param.Name, key)
+		}
+	}
+
+	switch param.Type {
+	case constants.TemplateParamTypeString, constants.TemplateParamTypeBool, constants.TemplateParamTypeInt, constants.TemplateParamTypeURL:
+	default:
+		return param, fmt.Errorf("parameter %v has unknown type %v, expected one of: %v", param.Name, param.Type, strings.Join(constants.TemplateParamTypes, ", "))
+	}
+
+	if param.Required && param.HasDefault {
+		return param, fmt.Errorf("parameter %v is required, so it can't have a default", param.Name)
+	}
+	if param.HasDefault {
+		err = param.validate(param.Default)
+		if err != nil {
+			return param, fmt.Errorf("invalid default: %v", err.Error())
+		}
+	}
+
+	return param, nil
+}
+
+// applyTemplateParams checks the attributes of a <template> tag against the
+// parameters declared by its template, and sets the default of every
+// parameter that wasn't passed. Optional parameters without a default are
+// set to an empty string, so that templates can use them without them
+// being passed. Attributes that aren't declared are left alone, so
+// templates without declarations keep working as before.
+func applyTemplateParams(params []TemplateParam, attributes map[string]string) error {
+	for _, param := range params {
+		value, ok := attributes[param.Name]
+		if !ok {
+			if param.Required {
+				return fmt.Errorf("missing required parameter %v", param.Name)
+			}
+			attributes[param.Name] = param.Default
+			continue
+		}
+
+		err := param.validate(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ListTemplates returns every template file in the templates directory,
+// including subdirectories, along with the parameters it declares. A
+// template whose declarations can't be parsed is listed with the error.
+func ListTemplates(conf *config.Config) (templates []TemplateInfo, err error) {
+	templates = []TemplateInfo{}
+	root := filepath.Clean(conf.Directories.Templates)
+
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// hidden files, such as .gitkeep or editor swap files, aren't
+		// templates
+		if p != root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		file, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		template := TemplateInfo{File: filepath.ToSlash(file), Params: []TemplateParam{}}
+
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("failed to read template file %v: %v", template.File, err.Error())
+		}
+		params, _, err := SplitTemplateParams(content)
+		if err != nil {
+			template.Error = err.Error()
+		} else {
+			template.Params = params
+		}
+
+		templates = append(templates, template)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates in %v: %v", conf.Directories.Templates, err.Error())
+	}
+
+	return templates, nil
+}
@@ -0,0 +1,182 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+// TestSplitTemplateParams validates that parameter declarations are parsed
+// from the front matter of a template file
+func TestSplitTemplateParams(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName     string
+		InputContent string
+		ExpectParams []TemplateParam
+		ExpectBody   string
+		ExpectError  string
+	}{
+		{
+			"SplitTemplateParams no front matter",
+			"<div>{{text}}</div>\n",
+			[]TemplateParam{},
+			"<div>{{text}}</div>\n",
+			"",
+		},
+		{
+			"SplitTemplateParams every option",
+			"---\nparams:\n  - name: text\n    required: true\n    description: the text\n  - name: level\n    default: info\n    allowed: [info, warning]\n  - name: count\n    type: int\n    default: 3\n---\n<div>{{text}}</div>\n",
+			[]TemplateParam{
+				{Name: "text", Type: constants.TemplateParamTypeString, Required: true, Description: "the text"},
+				{Name: "level", Type: constants.TemplateParamTypeString, Default: "info", HasDefault: true, Allowed: []string{"info", "warning"}},
+				{Name: "count", Type: constants.TemplateParamTypeInt, Default: "3", HasDefault: true},
+			},
+			"<div>{{text}}</div>\n",
+			"",
+		},
+		{
+			"SplitTemplateParams TOML",
+			"+++\n[[params]]\nname = \"text\"\nrequired = true\n+++\n<div>{{text}}</div>\n",
+			[]TemplateParam{
+				{Name: "text", Type: constants.TemplateParamTypeString, Required: true},
+			},
+			"<div>{{text}}</div>\n",
+			"",
+		},
+		{
+			"SplitTemplateParams front matter without params",
+			"---\nauthor: me\n---\n<div></div>\n",
+			[]TemplateParam{},
+			"<div></div>\n",
+			"",
+		},
+		{"SplitTemplateParams params not a list", "---\nparams: text\n---\n", nil, "", "params must be a list of parameters"},
+		{"SplitTemplateParams missing name", "---\nparams:\n  - required: true\n---\n", nil, "", "parameter is missing its name"},
+		{"SplitTemplateParams duplicate", "---\nparams:\n  - name: a\n  - name: a\n---\n", nil, "", "parameter a is declared more than once"},
+		{"SplitTemplateParams unknown option", "---\nparams:\n  - name: a\n    requried: true\n---\n", nil, "", "parameter a has unknown option requried"},
+		{"SplitTemplateParams unknown type", "---\nparams:\n  - name: a\n    type: float\n---\n", nil, "", "parameter a has unknown type float, expected one of: string, bool, int, url"},
+		{"SplitTemplateParams required with default", "---\nparams:\n  - name: a\n    required: true\n    default: b\n---\n", nil, "", "parameter a is required, so it can't have a default"},
+		{"SplitTemplateParams invalid default", "---\nparams:\n  - name: a\n    type: bool\n    default: maybe\n---\n", nil, "", `invalid default: parameter a has value "maybe", expected true or false`},
+		{"SplitTemplateParams default not allowed", "---\nparams:\n  - name: a\n    default: c\n    allowed: [a, b]\n---\n", nil, "", `invalid default: parameter a has value "c", expected one of: a, b`},
+	}
+
+	for _, test := range tests {
+		params, body, err := SplitTemplateParams([]byte(test.InputContent))
+		if test.ExpectError != "" {
+			assert.EqualError(err, test.ExpectError, test.TestName)
+			continue
+		}
+		assert.NoError(err, test.TestName)
+		assert.Equal(test.ExpectParams, params, test.TestName)
+		assert.Equal(test.ExpectBody, string(body), test.TestName)
+	}
+}
+
+// TestTemplateParams validates that templates apply the defaults of their
+// declared parameters, and fail the document when a parameter is invalid
+func TestTemplateParams(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName     string
+		InputHTML    string
+		ExpectOutput string
+		ExpectError  string
+	}{
+		{
+			"TemplateParams defaults",
+			`<template file="notice.html" text="Hi"></template>`,
+			`<div class="notice notice-info" data-dismissible="false"><a href="/">Hi</a></div>`,
+			"",
+		},
+		{
+			"TemplateParams every parameter",
+			`<template file="notice.html" text="Hi" level="danger" dismissible="true" href="https://example.com/a"></template>`,
+			`<div class="notice notice-danger" data-dismissible="true"><a href="https://example.com/a">Hi</a></div>`,
+			"",
+		},
+		{
+			"TemplateParams undeclared attributes are kept",
+			`<template file="notice.html" text="Hi" heading="false" extra="x"></template>`,
+			`<div class="notice notice-info" data-dismissible="false"><a href="/">Hi</a></div>`,
+			"",
+		},
+		{
+			"TemplateParams optional without default is empty",
+			`<template file="tag.html" label="Go"></template>`,
+			`<span class="tag" title="">Go</span>`,
+			"",
+		},
+		{
+			"TemplateParams optional without default",
+			`<template file="tag.html" label="Go" tooltip="A language"></template>`,
+			`<span class="tag" title="A language">Go</span>`,
+			"",
+		},
+		{"TemplateParams missing required", `<template file="notice.html"></template>`, "", "invalid use of template notice.html: missing required parameter text"},
+		{"TemplateParams not allowed", `<template file="notice.html" text="Hi" level="success"></template>`, "", `invalid use of template notice.html: parameter level has value "success", expected one of: info, warning, danger`},
+		{"TemplateParams invalid bool", `<template file="notice.html" text="Hi" dismissible="yes please"></template>`, "", `invalid use of template notice.html: parameter dismissible has value "yes please", expected true or false`},
+		{"TemplateParams unsafe url", `<template file="notice.html" text="Hi" href="javascript:alert(1)"></template>`, "", `invalid use of template notice.html: parameter href has value "javascript:alert(1)", expected a relative URL or one of the schemes: http, https, mailto, tel`},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		document := Document{Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader("<body>" + test.InputHTML + "</body>"))
+		require.NoError(err, test.TestName)
+
+		err = document.ProcessTemplateNode(helpers.GetNodeOfType(doc, constants.TemplateNode), doc)
+		if test.ExpectError != "" {
+			assert.EqualError(err, test.ExpectError, test.TestName)
+			continue
+		}
+		require.NoError(err, test.TestName)
+
+		output, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+		require.NoError(err, test.TestName)
+		assert.Equal("<body>"+test.ExpectOutput+"</body>", output, test.TestName)
+	}
+}
+
+func TestListTemplates(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+
+	templates, err := ListTemplates(&conf)
+	require.NoError(err)
+
+	found := map[string]TemplateInfo{}
+	for _, template := range templates {
+		found[template.File] = template
+	}
+
+	require.Contains(found, "notice.html")
+	assert.Empty(found["notice.html"].Error)
+	names := []string{}
+	for _, param := range found["notice.html"].Params {
+		names = append(names, param.Name)
+	}
+	assert.Equal([]string{"text", "level", "dismissible", "href"}, names)
+
+	require.Contains(found, "alert.html")
+	assert.Empty(found["alert.html"].Params)
+
+	// hidden files and directories aren't templates
+	assert.NotContains(found, ".gitkeep")
+	assert.NotContains(found, ".drafts/draft.html")
+}
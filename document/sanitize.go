@@ -0,0 +1,195 @@
+package document
+
+import (
+	"lightsites/constants"
+
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Violation is a part of a rendered document that breaks the no-JavaScript
+// policy, such as a script element or an inline event handler
+type Violation struct {
+	Rule    string `json:"rule"`
+	Element string `json:"element"`
+	Detail  string `json:"detail"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%v in <%v>: %v", v.Rule, v.Element, v.Detail)
+}
+
+// urlAttributes lists the attributes whose values are loaded or navigated
+// to as URLs
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"data":       true,
+	"poster":     true,
+	"background": true,
+	"cite":       true,
+	"longdesc":   true,
+}
+
+// isJavaScriptURL returns true if the value would run JavaScript when
+// navigated to. Browsers ignore leading whitespace and control characters,
+// as well as tabs and newlines anywhere in the URL, so they are ignored here
+// too.
+func isJavaScriptURL(value string) bool {
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value)
+	return strings.HasPrefix(strings.ToLower(cleaned), "javascript:")
+}
+
+// refreshURL returns the URL that a <meta http-equiv="refresh"> tag with
+// the given content navigates to, such as "/next.html" for
+// `0; url='/next.html'`, or an empty string if it only reloads the page
+func refreshURL(content string) string {
+	i := strings.IndexAny(content, ";,")
+	if i < 0 {
+		return ""
+	}
+	target := strings.TrimSpace(content[i+1:])
+	if strings.HasPrefix(strings.ToLower(target), "url") {
+		rest := strings.TrimSpace(target[len("url"):])
+		if strings.HasPrefix(rest, "=") {
+			target = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(target, `"'`)
+}
+
+// iframeAllowed returns true if the iframe's source is on a host in the
+// configured allowlist
+func (document *Document) iframeAllowed(src string) bool {
+	u, err := url.Parse(strings.TrimSpace(src))
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	for _, host := range document.Config.Sanitize.IframeAllowlist {
+		if strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// getAttribute returns the value of an attribute of n, or an empty string
+func getAttribute(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// Sanitize enforces the no-JavaScript policy on the document tree. Script
+// elements, inline event handlers, javascript: URLs (including refreshes to
+// them), srcdoc attributes and iframes that aren't on the allowlist are
+// recorded in document.Violations. Depending on the
+// configured mode, they are then removed (strip), only reported (warn), or
+// cause an error (fail). Violations are logged by whoever renders the site,
+// rather than here, so that they are logged once per refresh.
+func (document *Document) Sanitize(doc *html.Node) error {
+	mode := document.Config.Sanitize.Mode
+	if mode == "" {
+		mode = constants.SanitizeModeStrip
+	}
+	if mode == constants.SanitizeModeOff {
+		return nil
+	}
+	strip := mode == constants.SanitizeModeStrip
+
+	document.Violations = nil
+
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type != html.ElementNode {
+				c = next
+				continue
+			}
+
+			remove := false
+			switch c.Data {
+			case constants.ScriptNode:
+				detail := "inline script"
+				src := getAttribute(c, "src")
+				if src != "" {
+					detail = src
+				}
+				document.Violations = append(document.Violations, Violation{constants.ViolationScript, c.Data, detail})
+				remove = true
+			case constants.IframeNode:
+				src := getAttribute(c, "src")
+				if !document.iframeAllowed(src) {
+					document.Violations = append(document.Violations, Violation{constants.ViolationIframe, c.Data, fmt.Sprintf("source %q is not on the allowlist", src)})
+					remove = true
+				}
+			case constants.MetaNode:
+				if strings.EqualFold(strings.TrimSpace(getAttribute(c, "http-equiv")), "refresh") && isJavaScriptURL(refreshURL(getAttribute(c, "content"))) {
+					document.Violations = append(document.Violations, Violation{constants.ViolationJavaScriptURL, c.Data, "refresh to a javascript: URL"})
+					remove = true
+				}
+			}
+			if remove && strip {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+
+			attributes := []html.Attribute{}
+			for _, attr := range c.Attr {
+				key := strings.ToLower(attr.Key)
+				violation := false
+				if strings.HasPrefix(key, "on") {
+					document.Violations = append(document.Violations, Violation{constants.ViolationEventHandler, c.Data, fmt.Sprintf("%v attribute", attr.Key)})
+					violation = true
+				} else if urlAttributes[key] && isJavaScriptURL(attr.Val) {
+					document.Violations = append(document.Violations, Violation{constants.ViolationJavaScriptURL, c.Data, fmt.Sprintf("%v attribute", attr.Key)})
+					violation = true
+				} else if key == "srcdoc" {
+					// browsers render srcdoc instead of src, so it can hold
+					// scripts even in an allowed iframe
+					document.Violations = append(document.Violations, Violation{constants.ViolationIframe, c.Data, fmt.Sprintf("%v attribute", attr.Key)})
+					violation = true
+				}
+				if violation && strip {
+					continue
+				}
+				attributes = append(attributes, attr)
+			}
+			c.Attr = attributes
+
+			f(c)
+			c = next
+		}
+	}
+
+	f(doc)
+
+	if len(document.Violations) == 0 {
+		return nil
+	}
+
+	if mode == constants.SanitizeModeFail {
+		violations := []string{}
+		for _, v := range document.Violations {
+			violations = append(violations, v.String())
+		}
+		return fmt.Errorf("document breaks the no-JavaScript policy: %v", strings.Join(violations, "; "))
+	}
+
+	return nil
+}
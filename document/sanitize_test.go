@@ -0,0 +1,237 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+// TestSanitize validates that the no-JavaScript policy is enforced in each
+// sanitize mode
+func TestSanitize(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	input := `<p onclick="alert(1)">text</p>` +
+		`<script src="/tracker.js"></script>` +
+		`<script>alert(1)</script>` +
+		`<a href=" JaVa&#x09;Script:alert(1)" title="javascript: is not a url here">link</a>` +
+		`<a href="https://example.com">safe</a>` +
+		`<iframe src="https://www.youtube-nocookie.com/embed/x"></iframe>` +
+		`<iframe src="https://evil.example.com/"></iframe>` +
+		`<svg><script>alert(1)</script></svg>`
+
+	tests := []struct {
+		TestName         string
+		InputMode        string
+		ExpectError      bool
+		ExpectViolations []string
+		ExpectContains   []string
+		ExpectMissing    []string
+	}{
+		{
+			"Sanitize strip",
+			constants.SanitizeModeStrip,
+			false,
+			[]string{
+				constants.ViolationEventHandler,
+				constants.ViolationScript,
+				constants.ViolationScript,
+				constants.ViolationJavaScriptURL,
+				constants.ViolationIframe,
+				constants.ViolationScript,
+			},
+			[]string{`<p>text</p>`, `<a title="javascript: is not a url here">link</a>`, `href="https://example.com"`, `youtube-nocookie.com`},
+			[]string{"onclick", "<script", "evil.example.com", "alert"},
+		},
+		{
+			"Sanitize default mode strips",
+			"",
+			false,
+			[]string{
+				constants.ViolationEventHandler,
+				constants.ViolationScript,
+				constants.ViolationScript,
+				constants.ViolationJavaScriptURL,
+				constants.ViolationIframe,
+				constants.ViolationScript,
+			},
+			[]string{`<p>text</p>`},
+			[]string{"onclick", "<script", "evil.example.com"},
+		},
+		{
+			"Sanitize warn",
+			constants.SanitizeModeWarn,
+			false,
+			[]string{
+				constants.ViolationEventHandler,
+				constants.ViolationScript,
+				constants.ViolationScript,
+				constants.ViolationJavaScriptURL,
+				constants.ViolationIframe,
+				constants.ViolationScript,
+			},
+			[]string{"onclick", "<script", "evil.example.com"},
+			[]string{},
+		},
+		{
+			"Sanitize fail",
+			constants.SanitizeModeFail,
+			true,
+			[]string{
+				constants.ViolationEventHandler,
+				constants.ViolationScript,
+				constants.ViolationScript,
+				constants.ViolationJavaScriptURL,
+				constants.ViolationIframe,
+				constants.ViolationScript,
+			},
+			[]string{},
+			[]string{},
+		},
+		{
+			"Sanitize off",
+			constants.SanitizeModeOff,
+			false,
+			[]string{},
+			[]string{"onclick", "<script", "evil.example.com"},
+			[]string{},
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Sanitize.Mode = test.InputMode
+		conf.Sanitize.IframeAllowlist = []string{"www.youtube-nocookie.com"}
+		document := Document{FileName: "test", Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader(input))
+		require.NoError(err, test.TestName)
+
+		err = document.Sanitize(doc)
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+		} else {
+			assert.NoError(err, test.TestName)
+		}
+
+		rules := []string{}
+		for _, violation := range document.Violations {
+			rules = append(rules, violation.Rule)
+		}
+		assert.Equal(test.ExpectViolations, rules, test.TestName)
+
+		output, err := helpers.RenderNode(doc)
+		require.NoError(err, test.TestName)
+		for _, substr := range test.ExpectContains {
+			assert.Contains(output, substr, test.TestName)
+		}
+		for _, substr := range test.ExpectMissing {
+			assert.NotContains(output, substr, test.TestName)
+		}
+	}
+}
+
+// TestSanitizeIframeContentAndRefresh validates that allowed iframes can't
+// carry their own content, and that refreshes can't navigate to scripts
+func TestSanitizeIframeContentAndRefresh(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName         string
+		InputHTML        string
+		InputMode        string
+		ExpectViolations []string
+		ExpectOutput     string
+	}{
+		{
+			"Sanitize strips srcdoc from allowed iframes",
+			`<iframe src="https://www.youtube-nocookie.com/embed/x" srcdoc="<script>alert(1)</script>"></iframe>`,
+			constants.SanitizeModeStrip,
+			[]string{constants.ViolationIframe},
+			`<iframe src="https://www.youtube-nocookie.com/embed/x"></iframe>`,
+		},
+		{
+			"Sanitize keeps srcdoc in warn mode",
+			`<iframe src="https://www.youtube-nocookie.com/embed/x" srcdoc="<b>hi</b>"></iframe>`,
+			constants.SanitizeModeWarn,
+			[]string{constants.ViolationIframe},
+			`<iframe src="https://www.youtube-nocookie.com/embed/x" srcdoc="&lt;b&gt;hi&lt;/b&gt;"></iframe>`,
+		},
+		{
+			"Sanitize strips refreshes to javascript: URLs",
+			`<meta http-equiv="Refresh" content="0; URL='javascript:alert(1)'"/><p>text</p>`,
+			constants.SanitizeModeStrip,
+			[]string{constants.ViolationJavaScriptURL},
+			`<p>text</p>`,
+		},
+		{
+			"Sanitize keeps other refreshes",
+			`<meta http-equiv="refresh" content="5;url=/next.html"/><meta http-equiv="refresh" content="30"/>`,
+			constants.SanitizeModeStrip,
+			[]string{},
+			`<meta http-equiv="refresh" content="5;url=/next.html"/><meta http-equiv="refresh" content="30"/>`,
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Sanitize.Mode = test.InputMode
+		conf.Sanitize.IframeAllowlist = []string{"www.youtube-nocookie.com"}
+		document := Document{FileName: "test", Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader(test.InputHTML))
+		require.NoError(err, test.TestName)
+		require.NoError(document.Sanitize(doc), test.TestName)
+
+		rules := []string{}
+		for _, violation := range document.Violations {
+			rules = append(rules, violation.Rule)
+		}
+		assert.Equal(test.ExpectViolations, rules, test.TestName)
+
+		output, err := helpers.RenderNode(doc)
+		require.NoError(err, test.TestName)
+		assert.Contains(output, test.ExpectOutput, test.TestName)
+	}
+
+	conf := config.GetDefaultConfig()
+	conf.Sanitize.Mode = constants.SanitizeModeFail
+	conf.Sanitize.IframeAllowlist = []string{"www.youtube-nocookie.com"}
+	document := Document{FileName: "test", Config: &conf}
+	doc, err := html.Parse(strings.NewReader(`<iframe src="https://www.youtube-nocookie.com/embed/x" srcdoc="<script>alert(1)</script>"></iframe><meta http-equiv="refresh" content="0;url=javascript:alert(1)"/>`))
+	require.NoError(err)
+	assert.Error(document.Sanitize(doc))
+	assert.Len(document.Violations, 2)
+}
+
+// TestNewDocumentSanitized validates that content added by templates is
+// sanitized too
+func TestNewDocumentSanitized(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/sanitize-docs"
+	documentDirectory := []string{"scripts"}
+
+	doc, err := NewDocument(&conf, &documentDirectory, nil, "scripts")
+	require.NoError(err)
+	assert.Len(doc.Violations, 2)
+	assert.NotContains(doc.FileContents, "<script")
+	assert.NotContains(doc.FileContents, "onmouseover")
+
+	conf.Sanitize.Mode = constants.SanitizeModeFail
+	doc, err = NewDocument(&conf, &documentDirectory, nil, "scripts")
+	assert.Error(err)
+	assert.Len(doc.Violations, 2)
+}
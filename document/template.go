@@ -0,0 +1,689 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// templateContext is where a variable appears in a template, which decides
+// how its value is escaped
+type templateContext int
+
+const (
+	// text between tags, or in a comment
+	contextText templateContext = iota
+	contextComment
+	// inside a tag, but not in an attribute value
+	contextTagName
+	contextTag
+	contextAttrName
+	contextAfterAttrName
+	// right after the = of an attribute, before its value has started
+	contextBeforeValue
+	contextValueDouble
+	contextValueSingle
+	contextValueUnquoted
+	// the content of a <style> or <script> element, which isn't HTML
+	contextRawText
+)
+
+// rawTextElements lists the elements whose content is raw text, which
+// HTML escaping doesn't protect
+var rawTextElements = map[string]bool{
+	"style":              true,
+	constants.ScriptNode: true,
+}
+
+// safeURLSchemes lists the schemes that a variable may start a URL with
+var safeURLSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tel":    true,
+}
+
+// templateScanner tracks the HTML context while a template is scanned from
+// start to end, so that each variable can be escaped for where it appears
+type templateScanner struct {
+	context templateContext
+	// attrName is the name of the attribute being scanned, lowercased
+	attrName string
+	// value is the part of the current attribute value scanned so far
+	value string
+	// tagName is the name of the tag being scanned, lowercased, with a
+	// leading / for end tags
+	tagName string
+}
+
+// isURLAttribute returns true if the current attribute holds a URL
+func (s *templateScanner) isURLAttribute() bool {
+	return urlAttributes[s.attrName]
+}
+
+// step advances the scanner past the template source at rest, and returns
+// how many bytes were consumed
+func (s *templateScanner) step(rest string) int {
+	c := rest[0]
+	isSpace := c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+	inTag := s.context != contextText && s.context != contextComment && s.context != contextRawText
+
+	switch s.context {
+	case contextText:
+		if strings.HasPrefix(rest, "<!--") {
+			s.context = contextComment
+			return 4
+		}
+		if c == '<' && len(rest) > 1 && (rest[1] == '/' || (rest[1]|0x20 >= 'a' && rest[1]|0x20 <= 'z')) {
+			s.context = contextTagName
+			s.tagName = ""
+		}
+	case contextRawText:
+		// raw text only ends at the end tag of its element
+		end := "</" + strings.TrimPrefix(s.tagName, "/")
+		if len(rest) >= len(end) && strings.EqualFold(rest[:len(end)], end) {
+			s.context = contextTagName
+			s.tagName = ""
+		}
+	case contextComment:
+		if strings.HasPrefix(rest, "-->") {
+			s.context = contextText
+			return 3
+		}
+	case contextTagName:
+		if isSpace {
+			s.context = contextTag
+		} else if c == '>' {
+			s.context = contextText
+		} else {
+			s.tagName += strings.ToLower(string(c))
+		}
+	case contextTag:
+		if c == '>' {
+			s.context = contextText
+		} else if !isSpace && c != '/' {
+			s.context = contextAttrName
+			s.attrName = strings.ToLower(string(c))
+		}
+	case contextAttrName:
+		switch {
+		case c == '=':
+			s.context = contextBeforeValue
+		case isSpace:
+			s.context = contextAfterAttrName
+		case c == '>':
+			s.context = contextText
+		default:
+			s.attrName += strings.ToLower(string(c))
+		}
+	case contextAfterAttrName:
+		switch {
+		case c == '=':
+			s.context = contextBeforeValue
+		case c == '>':
+			s.context = contextText
+		case !isSpace && c != '/':
+			s.context = contextAttrName
+			s.attrName = strings.ToLower(string(c))
+		}
+	case contextBeforeValue:
+		s.value = ""
+		switch {
+		case c == '"':
+			s.context = contextValueDouble
+		case c == '\'':
+			s.context = contextValueSingle
+		case c == '>':
+			s.context = contextText
+		case !isSpace:
+			s.context = contextValueUnquoted
+			s.value = string(c)
+		}
+	case contextValueDouble:
+		if c == '"' {
+			s.context = contextTag
+		} else {
+			s.value += string(c)
+		}
+	case contextValueSingle:
+		if c == '\'' {
+			s.context = contextTag
+		} else {
+			s.value += string(c)
+		}
+	case contextValueUnquoted:
+		if isSpace {
+			s.context = contextTag
+		} else if c == '>' {
+			s.context = contextText
+		} else {
+			s.value += string(c)
+		}
+	}
+
+	// the content of a <style> or <script> start tag is raw text
+	if inTag && s.context == contextText && rawTextElements[s.tagName] {
+		s.context = contextRawText
+	}
+
+	return 1
+}
+
+// escapeCSS escapes a value used in the content of a <style> element.
+// Anything but letters, digits, spaces and the characters of common values
+// such as "#fff", "1.5em" or "50%" is written as a CSS escape, so that the
+// value can't end a string or rule, or the <style> element itself.
+func escapeCSS(value string) string {
+	var escaped strings.Builder
+	for _, r := range value {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune(" #.,%-_+", r):
+			escaped.WriteRune(r)
+		default:
+			escaped.WriteString(fmt.Sprintf("\\%x ", r))
+		}
+	}
+	return escaped.String()
+}
+
+// escapeURL escapes a value used in a URL attribute. A value that starts
+// the URL may not use a scheme that isn't in safeURLSchemes, and a value in
+// the middle of a URL is percent-encoded, as a query value if it follows a
+// ?, or as a path segment otherwise.
+func (s *templateScanner) escapeURL(key string, value string) (string, error) {
+	if strings.TrimSpace(s.value) == "" {
+		colon := strings.Index(value, ":")
+		if colon >= 0 && !strings.ContainsAny(value[:colon], "/?#") {
+			scheme := strings.ToLower(strings.TrimSpace(value[:colon]))
+			if !safeURLSchemes[scheme] {
+				return "", fmt.Errorf("variable %v has unsafe URL %q for attribute %v", key, value, s.attrName)
+			}
+		}
+		return html.EscapeString(value), nil
+	}
+	if strings.Contains(s.value, "?") {
+		return html.EscapeString(url.QueryEscape(value)), nil
+	}
+	return html.EscapeString(url.PathEscape(value)), nil
+}
+
+// escape returns the value of a variable escaped for the scanner's current
+// context
+func (s *templateScanner) escape(key string, value string) (string, error) {
+	switch s.context {
+	case contextText, contextComment:
+		return html.EscapeString(value), nil
+	case contextRawText:
+		if s.tagName != "style" {
+			return "", fmt.Errorf("variable %v can't be used inside <%v>", key, s.tagName)
+		}
+		return escapeCSS(value), nil
+	case contextBeforeValue:
+		// a variable that is the whole unquoted value is quoted, so that
+		// spaces in it can't start another attribute
+		escaped := html.EscapeString(value)
+		if s.isURLAttribute() {
+			var err error
+			escaped, err = s.escapeURL(key, value)
+			if err != nil {
+				return "", err
+			}
+		}
+		s.context = contextTag
+		return fmt.Sprintf(`"%v"`, escaped), nil
+	case contextValueDouble, contextValueSingle, contextValueUnquoted:
+		escaped := html.EscapeString(value)
+		if s.isURLAttribute() {
+			var err error
+			escaped, err = s.escapeURL(key, value)
+			if err != nil {
+				return "", err
+			}
+		}
+		if s.context == contextValueUnquoted {
+			escaped = strings.NewReplacer(" ", "&#32;", "\t", "&#9;", "\n", "&#10;", "\r", "&#13;", "\f", "&#12;", "=", "&#61;", "`", "&#96;").Replace(escaped)
+		}
+		s.value += value
+		return escaped, nil
+	}
+
+	return "", fmt.Errorf("variable %v can't be used inside a tag outside of an attribute value; use {{{%v}}} for trusted markup", key, key)
+}
+
+// substituteVariables replaces the variables in a template's source with
+// their values. {{key}} is escaped for where it appears: as text, inside an
+// attribute value, as (part of) a URL, or inside a <style> element. It
+// can't be used inside a <script> element. {{{key}}} inserts the value as is,
+// for trusted markup. A variable that isn't in variables is an error.
+//
+// {{content}} and {{slot:name}} insert the inner HTML of the <template> tag
+// and of its named slots from slots, which is already markup and so is never
+// escaped. Slots can only be used as text, not inside tags.
+func substituteVariables(content string, variables map[string]string, slots map[string]string) (string, error) {
+	var output strings.Builder
+	scanner := templateScanner{}
+
+	for i := 0; i < len(content); {
+		rest := content[i:]
+		if !strings.HasPrefix(rest, "{{") {
+			n := scanner.step(rest)
+			output.WriteString(rest[:n])
+			i += n
+			continue
+		}
+
+		raw := strings.HasPrefix(rest, "{{{")
+		open, close := "{{", "}}"
+		if raw {
+			open, close = "{{{", "}}}"
+		}
+		end := strings.Index(rest[len(open):], close)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable %v at offset %v", open, i)
+		}
+		key := strings.TrimSpace(rest[len(open) : len(open)+end])
+		i += len(open) + end + len(close)
+
+		slotName, isSlot := slotKey(key)
+		if isSlot {
+			slot, ok := slots[slotName]
+			if !ok {
+				return "", fmt.Errorf("slot %v was not supplied", slotName)
+			}
+			if scanner.context != contextText {
+				return "", fmt.Errorf("slot %v can only be used as text, not inside a tag", slotName)
+			}
+			output.WriteString(slot)
+			continue
+		}
+
+		value, ok := variables[key]
+		if !ok {
+			return "", fmt.Errorf("variable %v was not supplied", key)
+		}
+
+		if raw {
+			output.WriteString(value)
+			continue
+		}
+
+		escaped, err := scanner.escape(key, value)
+		if err != nil {
+			return "", err
+		}
+		output.WriteString(escaped)
+	}
+
+	return output.String(), nil
+}
+
+// slotKey returns the name of the slot that a legacy template variable
+// refers to: {{content}} is the default slot, and {{slot:name}} is a named
+// slot. ok is false for ordinary variables.
+func slotKey(key string) (name string, ok bool) {
+	if key == constants.DefaultSlot {
+		return constants.DefaultSlot, true
+	}
+	if strings.HasPrefix(key, constants.SlotVariablePrefix) {
+		return strings.TrimPrefix(key, constants.SlotVariablePrefix), true
+	}
+	return "", false
+}
+
+// TemplateData is the data that html/template templates are executed with
+type TemplateData struct {
+	// Attributes holds the attributes of the <template> tag. Since their
+	// names usually contain hyphens, they are read with
+	// {{index .Attributes "alert-text"}} or {{attr "alert-text"}}.
+	Attributes map[string]string
+	// Document is the document being rendered. Its title, attributes and
+	// dates have already been processed.
+	Document *Document
+	// Content is the inner HTML of the <template> tag, excluding named slots
+	Content template.HTML
+	// Slots holds the inner HTML of each named <slot> in the <template> tag
+	Slots map[string]template.HTML
+	Site  SiteData
+}
+
+// SiteData describes the site that a template is rendered in
+type SiteData struct {
+	// Documents lists the names of every document in the site
+	Documents []string
+	// Index holds the metadata of every document in the site, sorted by
+	// file name
+	Index  []IndexEntry
+	Config *config.Config
+}
+
+// templateFuncs returns the functions available to html/template templates
+func templateFuncs(attributes map[string]string, slots map[string]string) template.FuncMap {
+	return template.FuncMap{
+		// slot returns the inner HTML of a named slot, and fails if the
+		// <template> tag doesn't have it
+		"slot": func(name string) (template.HTML, error) {
+			value, ok := slots[name]
+			if !ok {
+				return "", fmt.Errorf("slot %v was not supplied", name)
+			}
+			return template.HTML(value), nil
+		},
+		// attr returns a tag attribute, or an empty string if it isn't set
+		"attr": func(key string) string {
+			return attributes[key]
+		},
+		// default returns fallback if value is empty, as in
+		// {{attr "level" | default "primary"}}
+		"default": func(fallback string, value string) string {
+			if value == "" {
+				return fallback
+			}
+			return value
+		},
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"join":  strings.Join,
+		"split": func(value string, separator string) []string {
+			parts := []string{}
+			for _, part := range strings.Split(value, separator) {
+				part = strings.TrimSpace(part)
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			return parts
+		},
+		// formatDate formats a time with a Go reference layout, such as
+		// {{formatDate "2006-01-02" .Document.Date}}
+		"formatDate": func(layout string, t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(layout)
+		},
+		// raw marks a trusted value as HTML, so that it isn't escaped
+		"raw": func(value string) template.HTML {
+			return template.HTML(value)
+		},
+	}
+}
+
+// indexFuncs returns the functions that give html/template templates access
+// to the metadata of the other documents in the site
+func (document *Document) indexFuncs() template.FuncMap {
+	return template.FuncMap{
+		// doc returns the index entry of a document, as in
+		// {{(doc "blog/post").Title}}, and fails if there is no such document
+		"doc": func(fileName string) (IndexEntry, error) {
+			entry, ok := document.Index[strings.Trim(fileName, "/")]
+			if !ok {
+				return entry, fmt.Errorf("document %v does not exist", fileName)
+			}
+			return entry, nil
+		},
+		// backlinks returns the entries of the documents that link to the
+		// document being rendered
+		"backlinks": func() []IndexEntry {
+			return document.Index.Backlinks(document.FileName)
+		},
+	}
+}
+
+// templateEngine returns the engine that the template file is rendered with
+func (document *Document) templateEngine(templateFile string) string {
+	if path.Ext(templateFile) == constants.HTMLTemplateFileExtension {
+		return constants.TemplateEngineHTML
+	}
+	if document.Config.Templates.Engine == "" {
+		return constants.TemplateEngineLegacy
+	}
+	return document.Config.Templates.Engine
+}
+
+// renderTemplate renders the source of a template file with the attributes
+// and slots of the <template> tag, using the engine configured for the file
+func (document *Document) renderTemplate(templateFile string, content string, attributes map[string]string, slots map[string]string) (string, error) {
+	if document.templateEngine(templateFile) == constants.TemplateEngineLegacy {
+		return substituteVariables(content, attributes, slots)
+	}
+
+	tmpl, err := template.New(templateFile).Funcs(templateFuncs(attributes, slots)).Funcs(document.indexFuncs()).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return "", err
+	}
+
+	// anything in the index may be used, so the template has to be rendered
+	// again when any document's metadata changes
+	document.UsesIndex = true
+
+	documents := []string{}
+	if document.DocumentDirectory != nil {
+		documents = append(documents, *document.DocumentDirectory...)
+	}
+	namedSlots := make(map[string]template.HTML)
+	for name, value := range slots {
+		if name != constants.DefaultSlot {
+			namedSlots[name] = template.HTML(value)
+		}
+	}
+	data := TemplateData{
+		Attributes: attributes,
+		Document:   document,
+		Content:    template.HTML(slots[constants.DefaultSlot]),
+		Slots:      namedSlots,
+		Site: SiteData{
+			Documents: documents,
+			Index:     document.Index.Entries(),
+			Config:    document.Config,
+		},
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// isBlank returns true for nodes that don't contribute any content at the
+// edges of a slot: whitespace, and the empty paragraphs that markdown leaves
+// around the <template> tags
+func isBlank(n *xhtml.Node) bool {
+	switch n.Type {
+	case xhtml.TextNode:
+		return strings.TrimSpace(n.Data) == ""
+	case xhtml.CommentNode:
+		return true
+	case xhtml.ElementNode:
+		return n.Data == constants.ParagraphNode && n.FirstChild == nil
+	}
+	return false
+}
+
+// renderNodes renders a list of sibling nodes, leaving out blank nodes at
+// the start and end
+func renderNodes(nodes []*xhtml.Node) (string, error) {
+	for len(nodes) > 0 && isBlank(nodes[0]) {
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 && isBlank(nodes[len(nodes)-1]) {
+		nodes = nodes[:len(nodes)-1]
+	}
+
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		err := xhtml.Render(&buf, n)
+		if err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// templateSlots collects the inner HTML of a <template> tag for the
+// template's slots. <slot name="..."> elements anywhere inside the tag fill
+// the named slots (markdown usually wraps them in a paragraph), except
+// inside nested <template> tags, and everything else fills the default slot.
+func templateSlots(n *xhtml.Node) (map[string]string, error) {
+	slots := make(map[string]string)
+
+	slotNodes := []*xhtml.Node{}
+	var f func(*xhtml.Node)
+	f = func(parent *xhtml.Node) {
+		for c := parent.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != xhtml.ElementNode || c.Data == constants.TemplateNode {
+				continue
+			}
+			if c.Data == constants.SlotNode {
+				slotNodes = append(slotNodes, c)
+				continue
+			}
+			f(c)
+		}
+	}
+	f(n)
+
+	for _, slotNode := range slotNodes {
+		name := getAttribute(slotNode, constants.SlotNameKey)
+		if name == "" {
+			return slots, fmt.Errorf("<%v> elements in a template tag must have a %v attribute", constants.SlotNode, constants.SlotNameKey)
+		}
+		_, exists := slots[name]
+		if exists {
+			return slots, fmt.Errorf("slot %v is filled more than once", name)
+		}
+
+		rendered, err := renderNodes(childNodes(slotNode))
+		if err != nil {
+			return slots, fmt.Errorf("failed to render slot %v: %v", name, err.Error())
+		}
+		slots[name] = rendered
+
+		// remove the paragraph that markdown wrapped around the slot too, if
+		// the slot was all it held
+		parent := slotNode.Parent
+		parent.RemoveChild(slotNode)
+		if parent != n && parent.Data == constants.ParagraphNode && len(childNodes(parent)) == 0 {
+			parent.Parent.RemoveChild(parent)
+		}
+	}
+
+	rendered, err := renderNodes(childNodes(n))
+	if err != nil {
+		return slots, fmt.Errorf("failed to render template content: %v", err.Error())
+	}
+	slots[constants.DefaultSlot] = rendered
+
+	return slots, nil
+}
+
+// childNodes returns the children of n
+func childNodes(n *xhtml.Node) []*xhtml.Node {
+	children := []*xhtml.Node{}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, c)
+	}
+	return children
+}
+
+// parseTemplateHTML parses a rendered template into the nodes to insert
+// where the template is used, and the nodes to hoist into the page <head>.
+// A template declares head nodes, such as <meta> and <link> tags, by
+// wrapping them in a <head> element. Whitespace at the edges of the
+// template is dropped, but every other top-level node is kept in order.
+func parseTemplateHTML(content string) (head []*xhtml.Node, body []*xhtml.Node, err error) {
+	headSource, bodySource := splitTemplateHead(content)
+
+	if headSource != "" {
+		head, err = xhtml.ParseFragment(strings.NewReader(headSource), &xhtml.Node{Type: xhtml.ElementNode, Data: constants.HeadNode, DataAtom: atom.Head})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	body, err = xhtml.ParseFragment(strings.NewReader(bodySource), &xhtml.Node{Type: xhtml.ElementNode, Data: constants.BodyNode, DataAtom: atom.Body})
+	if err != nil {
+		return nil, nil, err
+	}
+	for len(body) > 0 && body[0].Type == xhtml.TextNode && strings.TrimSpace(body[0].Data) == "" {
+		body = body[1:]
+	}
+	for len(body) > 0 && body[len(body)-1].Type == xhtml.TextNode && strings.TrimSpace(body[len(body)-1].Data) == "" {
+		body = body[:len(body)-1]
+	}
+
+	return head, body, nil
+}
+
+// splitTemplateHead separates the inner HTML of a template's <head>
+// element, if it has one, from the rest of the template
+func splitTemplateHead(content string) (head string, body string) {
+	tokenizer := xhtml.NewTokenizer(strings.NewReader(content))
+	offset := 0
+	start := -1
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == xhtml.ErrorToken {
+			return "", content
+		}
+		raw := len(tokenizer.Raw())
+		name, _ := tokenizer.TagName()
+		switch {
+		case tokenType == xhtml.StartTagToken && string(name) == constants.HeadNode && start < 0:
+			start = offset
+			head = content[offset+raw:]
+		case tokenType == xhtml.EndTagToken && string(name) == constants.HeadNode && start >= 0:
+			headStart := len(content) - len(head)
+			return content[headStart:offset], content[:start] + content[offset+raw:]
+		}
+		offset += raw
+	}
+}
+
+// hoistHeadNodes appends nodes declared by a template to the page <head>,
+// skipping nodes that are already there, such as a stylesheet link from a
+// template that is used more than once
+func (document *Document) hoistHeadNodes(parentDoc *xhtml.Node, nodes []*xhtml.Node) {
+	if len(nodes) == 0 {
+		return
+	}
+	headNode := helpers.GetNodeOfType(parentDoc, constants.HeadNode)
+	if headNode == nil {
+		log.Printf("document %v has no head to add template head content to", document.FileName)
+		return
+	}
+
+	existing := make(map[string]bool)
+	for c := headNode.FirstChild; c != nil; c = c.NextSibling {
+		rendered, err := helpers.RenderNode(c)
+		if err == nil {
+			existing[rendered] = true
+		}
+	}
+
+	for _, n := range nodes {
+		if n.Type == xhtml.TextNode && strings.TrimSpace(n.Data) == "" {
+			continue
+		}
+		rendered, err := helpers.RenderNode(n)
+		if err != nil || existing[rendered] {
+			continue
+		}
+		existing[rendered] = true
+		headNode.AppendChild(n)
+	}
+}
@@ -0,0 +1,358 @@
+package document
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+func TestSubstituteVariables(t *testing.T) {
+	assert := assert.New(t)
+
+	variables := map[string]string{
+		"text":   `<b>"Tom" & 'Jerry'</b>`,
+		"class":  `primary" onclick="alert(1)`,
+		"spaced": `a b=c`,
+		"link":   "https://example.com/a?b=c&d=e",
+		"evil":   "javascript:alert(1)",
+		"evil2":  " JavaScript:alert(1)",
+		"page":   "a b/c",
+		"query":  "a&b=c d",
+		"mail":   "mailto:someone@example.com",
+		"rel":    "/docs/index.html",
+		"empty":  "",
+		"color":  "#fff",
+		"css":    `"}</style>`,
+	}
+
+	tests := []struct {
+		TestName     string
+		InputContent string
+		ExpectOutput string
+		ExpectError  bool
+	}{
+		{"SubstituteVariables text", `<div>{{text}}</div>`, `<div>&lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt;</div>`, false},
+		{"SubstituteVariables raw", `<div>{{{text}}}</div>`, `<div><b>"Tom" & 'Jerry'</b></div>`, false},
+		{"SubstituteVariables whitespace in braces", `<div>{{ text }}</div>`, `<div>&lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt;</div>`, false},
+		{"SubstituteVariables double quoted attribute", `<div class="alert {{class}}"></div>`, `<div class="alert primary&#34; onclick=&#34;alert(1)"></div>`, false},
+		{"SubstituteVariables single quoted attribute", `<div class='{{class}}'></div>`, `<div class='primary&#34; onclick=&#34;alert(1)'></div>`, false},
+		{"SubstituteVariables unquoted attribute", `<div class={{spaced}}></div>`, `<div class="a b=c"></div>`, false},
+		{"SubstituteVariables inside unquoted attribute", `<div class=x-{{spaced}}></div>`, `<div class=x-a&#32;b&#61;c></div>`, false},
+		{"SubstituteVariables url", `<a href="{{link}}">x</a>`, `<a href="https://example.com/a?b=c&amp;d=e">x</a>`, false},
+		{"SubstituteVariables relative url", `<a href="{{rel}}">x</a>`, `<a href="/docs/index.html">x</a>`, false},
+		{"SubstituteVariables mailto url", `<a href="{{mail}}">x</a>`, `<a href="mailto:someone@example.com">x</a>`, false},
+		{"SubstituteVariables javascript url", `<a href="{{evil}}">x</a>`, ``, true},
+		{"SubstituteVariables javascript url with whitespace", `<a href="{{evil2}}">x</a>`, ``, true},
+		{"SubstituteVariables javascript text is fine", `<p>{{evil}}</p>`, `<p>javascript:alert(1)</p>`, false},
+		{"SubstituteVariables url path segment", `<a href="/tags/{{page}}.html">x</a>`, `<a href="/tags/a%20b%2Fc.html">x</a>`, false},
+		{"SubstituteVariables url query", `<a href="/search?q={{query}}">x</a>`, `<a href="/search?q=a%26b%3Dc+d">x</a>`, false},
+		{"SubstituteVariables comment", `<!-- {{text}} -->`, `<!-- &lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt; -->`, false},
+		{"SubstituteVariables empty value", `<div>{{empty}}</div>`, `<div></div>`, false},
+		{"SubstituteVariables text after tag", `<img src="a.png"> {{text}}`, `<img src="a.png"> &lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt;`, false},
+		{"SubstituteVariables style", `<style>.a { color: {{color}}; }</style>`, `<style>.a { color: #fff; }</style>`, false},
+		{"SubstituteVariables style escapes", `<style>.a { content: "{{css}}"; }</style><p>{{css}}</p>`, `<style>.a { content: "\22 \7d \3c \2f style\3e "; }</style><p>&#34;}&lt;/style&gt;</p>`, false},
+		{"SubstituteVariables style ends at its end tag", `<STYLE media="all">p {}</Style><p>{{text}}</p>`, `<STYLE media="all">p {}</Style><p>&lt;b&gt;&#34;Tom&#34; &amp; &#39;Jerry&#39;&lt;/b&gt;</p>`, false},
+		{"SubstituteVariables script", `<script>var a = "{{text}}";</script>`, ``, true},
+		{"SubstituteVariables inside tag", `<div {{class}}></div>`, ``, true},
+		{"SubstituteVariables raw inside tag", `<div {{{spaced}}}></div>`, `<div a b=c></div>`, false},
+		{"SubstituteVariables missing variable", `<div>{{missing}}</div>`, ``, true},
+		{"SubstituteVariables unterminated", `<div>{{text</div>`, ``, true},
+	}
+
+	for _, test := range tests {
+		output, err := substituteVariables(test.InputContent, variables, nil)
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+			continue
+		}
+		assert.NoError(err, test.TestName)
+		assert.Equal(test.ExpectOutput, output, test.TestName)
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName     string
+		InputEngine  string
+		InputFile    string
+		InputAttrs   map[string]string
+		ExpectOutput string
+		ExpectError  bool
+	}{
+		{
+			"RenderTemplate legacy engine",
+			constants.TemplateEngineLegacy,
+			"alert.html",
+			map[string]string{"alert-text": "<b>Hi</b>"},
+			`<div class="alert alert-primary">&lt;b&gt;Hi&lt;/b&gt;</div>`,
+			false,
+		},
+		{
+			"RenderTemplate gohtml files use html/template",
+			constants.TemplateEngineLegacy,
+			"card.gohtml",
+			map[string]string{"text": "<b>Hi</b>", "tags": "a, b"},
+			`<div class="card card-primary"><h5>Doc</h5><ul><li>a</li><li>b</li></ul><p>&lt;b&gt;Hi&lt;/b&gt;</p><small>2 documents</small></div>`,
+			false,
+		},
+		{
+			"RenderTemplate html/template attributes",
+			constants.TemplateEngineHTML,
+			"card.gohtml",
+			map[string]string{"text": "Hi", "level": "danger"},
+			`<div class="card card-danger"><h5>Doc</h5><p>Hi</p><small>2 documents</small></div>`,
+			false,
+		},
+		{
+			"RenderTemplate legacy syntax under html/template",
+			constants.TemplateEngineHTML,
+			"alert.html",
+			map[string]string{"alert-text": "Hi"},
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Templates.Engine = test.InputEngine
+		documentDirectory := []string{"index", "blog/post"}
+		document := Document{Title: "Doc", Config: &conf, DocumentDirectory: &documentDirectory}
+
+		content, err := ioutil.ReadFile("../tests/templates/" + test.InputFile)
+		require.NoError(err, test.TestName)
+
+		output, err := document.renderTemplate(test.InputFile, string(content), test.InputAttrs, map[string]string{})
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+			continue
+		}
+		assert.NoError(err, test.TestName)
+		assert.Equal(test.ExpectOutput, strings.TrimSpace(output), test.TestName)
+	}
+}
+
+// TestNestedTemplateErrors validates that cycles and excessive nesting are
+// reported with the chain of templates that led to them
+func TestNestedTemplateErrors(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName    string
+		InputHTML   string
+		InputDepth  int
+		ExpectError string
+	}{
+		{"NestedTemplates cycle", `<template file="cycle-a.html"></template>`, 10, "template cycle detected: cycle-a.html -> cycle-b.html -> cycle-a.html"},
+		{"NestedTemplates maximum depth", `<template file="card.html" card-title="a" badge="b" href="/c"></template>`, 1, "templates are nested deeper than the maximum depth of 1: card.html -> badge.html"},
+		{"NestedTemplates within maximum depth", `<template file="card.html" card-title="a" badge="b" href="/c"></template>`, 2, ""},
+		{"NestedTemplates same template in its own content", `<template file="callout.html">outer <template file="callout.html">inner<slot name="footer">b</slot></template><slot name="footer">a</slot></template>`, 1, ""},
+		{"NestedTemplates cycle through content", `<template file="cycle-a.html"><template file="cycle-a.html"></template></template>`, 10, "template cycle detected: cycle-a.html -> cycle-b.html -> cycle-a.html"},
+		{"NestedTemplates missing variable in nested template", `<template file="card.html" card-title="a" href="/c"></template>`, 10, "variable badge was not supplied"},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		conf.Templates.MaxDepth = test.InputDepth
+		document := Document{Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader(test.InputHTML))
+		require.NoError(err, test.TestName)
+
+		err = document.ProcessTemplateNode(helpers.GetNodeOfType(doc, constants.TemplateNode), doc)
+		if test.ExpectError == "" {
+			assert.NoError(err, test.TestName)
+			continue
+		}
+		require.Error(err, test.TestName)
+		assert.Contains(err.Error(), test.ExpectError, test.TestName)
+	}
+}
+
+func TestTemplateSlots(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName     string
+		InputHTML    string
+		ExpectOutput string
+		ExpectError  bool
+	}{
+		{
+			"TemplateSlots content and named slot",
+			`<template file="callout.html"><p>Hello <b>world</b></p><slot name="footer">Bye</slot></template>`,
+			`<div class="callout"><div class="callout-body"><p>Hello <b>world</b></p></div><footer>Bye</footer></div>`,
+			false,
+		},
+		{
+			"TemplateSlots slot wrapped in a paragraph",
+			`<template file="callout.html"><p></p><p>Hello</p><p><slot name="footer">Bye</slot></p><p></p></template>`,
+			`<div class="callout"><div class="callout-body"><p>Hello</p></div><footer>Bye</footer></div>`,
+			false,
+		},
+		{
+			"TemplateSlots empty content",
+			`<template file="callout.html"><slot name="footer">Bye</slot></template>`,
+			`<div class="callout"><div class="callout-body"></div><footer>Bye</footer></div>`,
+			false,
+		},
+		{
+			"TemplateSlots html/template",
+			`<template file="panel.gohtml" panel-title="Note"><ul><li>one</li></ul><slot name="footer"><i>Bye</i></slot></template>`,
+			`<div class="panel" title="Note"><ul><li>one</li></ul><footer><i>Bye</i></footer></div>`,
+			false,
+		},
+		{
+			"TemplateSlots missing named slot",
+			`<template file="callout.html"><p>Hello</p></template>`,
+			"",
+			true,
+		},
+		{
+			"TemplateSlots missing named slot in html/template",
+			`<template file="panel.gohtml" panel-title="Note"><p>Hello</p></template>`,
+			"",
+			true,
+		},
+		{
+			"TemplateSlots slot without a name",
+			`<template file="callout.html"><slot>Bye</slot></template>`,
+			"",
+			true,
+		},
+		{
+			"TemplateSlots slot filled twice",
+			`<template file="callout.html"><slot name="footer">a</slot><slot name="footer">b</slot></template>`,
+			"",
+			true,
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		document := Document{Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader("<body>" + test.InputHTML + "</body>"))
+		require.NoError(err, test.TestName)
+
+		err = document.ProcessTemplateNode(helpers.GetNodeOfType(doc, constants.TemplateNode), doc)
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+			continue
+		}
+		require.NoError(err, test.TestName)
+
+		output, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+		require.NoError(err, test.TestName)
+		assert.Equal("<body>"+test.ExpectOutput+"</body>", output, test.TestName)
+	}
+}
+
+// TestTemplateSlotsMarkdown validates that markdown inside a <template> tag
+// is rendered before it fills the slots
+func TestTemplateSlotsMarkdown(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/slot-docs"
+	documentDirectory := []string{"slots"}
+
+	doc, err := NewDocument(&conf, &documentDirectory, nil, "slots")
+	require.NoError(err)
+	assert.Contains(doc.FileContents, `<div class="callout-body"><p>Some <strong>markdown</strong> here.</p>`)
+	assert.Contains(doc.FileContents, "<li>one</li>")
+	assert.Contains(doc.FileContents, "<footer>Posted by <em>me</em></footer>")
+	assert.NotContains(doc.FileContents, "<slot")
+}
+
+// TestMultiNodeTemplates validates that every top-level node of a template
+// is inserted in order, and that head content is hoisted into the page head
+func TestMultiNodeTemplates(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName   string
+		InputHTML  string
+		ExpectHead string
+		ExpectBody string
+	}{
+		{
+			"MultiNodeTemplates every node in order",
+			`<span>a</span><template file="multi.html" heading-text="Title"></template><span>b</span>`,
+			`<head></head>`,
+			"<body><span>a</span><h3>Title</h3>\nSome text\n<p>Second</p><span>b</span></body>",
+		},
+		{
+			"MultiNodeTemplates heading",
+			`<span>a</span><template file="multi.html" heading="true" heading-text="Title"></template>`,
+			`<head></head>`,
+			"<body><span>a</span><region name=\"header\"><h3>Title</h3>\nSome text\n<p>Second</p></region></body>",
+		},
+		{
+			"MultiNodeTemplates head hoisting",
+			`<template file="styled.html" text="one"></template><template file="styled.html" text="two"></template>`,
+			`<head><link rel="stylesheet" href="/assets/styled.css"/></head>`,
+			`<body><div class="styled">one</div><div class="styled">two</div></body>`,
+		},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		document := Document{Config: &conf}
+
+		doc, err := html.Parse(strings.NewReader("<body>" + test.InputHTML + "</body>"))
+		require.NoError(err, test.TestName)
+
+		for n := helpers.GetNodeOfType(doc, constants.TemplateNode); n != nil; n = helpers.GetNodeOfType(doc, constants.TemplateNode) {
+			require.NoError(document.ProcessTemplateNode(n, doc), test.TestName)
+		}
+
+		head, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.HeadNode))
+		require.NoError(err, test.TestName)
+		assert.Equal(test.ExpectHead, head, test.TestName)
+		body, err := helpers.RenderNode(helpers.GetNodeOfType(doc, constants.BodyNode))
+		require.NoError(err, test.TestName)
+		assert.Equal(test.ExpectBody, body, test.TestName)
+	}
+}
+
+func TestSplitTemplateHead(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName    string
+		InputSource string
+		ExpectHead  string
+		ExpectBody  string
+	}{
+		{"SplitTemplateHead no head", `<div>a</div>`, "", `<div>a</div>`},
+		{"SplitTemplateHead head first", `<head><meta name="a"></head><div>a</div>`, `<meta name="a">`, `<div>a</div>`},
+		{"SplitTemplateHead head after content", `<div>a</div><HEAD><meta name="a"></HEAD><p>b</p>`, `<meta name="a">`, `<div>a</div><p>b</p>`},
+		{"SplitTemplateHead unterminated head", `<head><meta name="a"><div>a</div>`, "", `<head><meta name="a"><div>a</div>`},
+	}
+
+	for _, test := range tests {
+		head, body := splitTemplateHead(test.InputSource)
+		assert.Equal(test.ExpectHead, head, test.TestName)
+		assert.Equal(test.ExpectBody, body, test.TestName)
+	}
+}
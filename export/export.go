@@ -0,0 +1,112 @@
+package export
+
+import (
+	"lightsites/config"
+	"lightsites/document"
+	"lightsites/helpers"
+	"lightsites/site"
+
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Export renders every document in the configured documents directory and
+// writes the result to outputDir as a static site. Documents are written to
+// `<routePrefix><DocumentName><urlFileSuffix>`, generated files such as
+// feeds and the sitemap to `<routePrefix><path>`, robots.txt to the root of
+// outputDir, and assets are copied under the configured assets prefix, so
+// that the links produced by the renderer resolve the same way they do when
+// served live.
+//
+// Nothing is written if any document fails to parse, so that a broken
+// document can't result in a partially exported site.
+func Export(conf *config.Config, outputDir string) error {
+	s, err := site.Load(conf, 1, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(s.Errors) > 0 {
+		failures := []string{}
+		for _, file := range s.ErrorFiles() {
+			failures = append(failures, s.Errors[file].Error())
+		}
+		return fmt.Errorf("failed to process %v documents: %v", len(failures), strings.Join(failures, "; "))
+	}
+	s.LogViolations()
+
+	for _, doc := range s.Documents {
+		err = WriteDocument(conf, outputDir, &doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, generated := range s.GeneratedFiles() {
+		err = WriteGenerated(conf, outputDir, generated)
+		if err != nil {
+			return err
+		}
+	}
+
+	assetsDir := filepath.Join(outputDir, filepath.FromSlash(conf.Routing.AssetsPrefix))
+	err = helpers.CopyDirectory(conf.Directories.Assets, assetsDir)
+	if err != nil {
+		return fmt.Errorf("failed to copy assets: %v", err.Error())
+	}
+
+	if s.Robots != nil {
+		err = ioutil.WriteFile(filepath.Join(outputDir, filepath.FromSlash(s.Robots.Path)), []byte(s.Robots.Content), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write %v: %v", s.Robots.Path, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// WriteDocument writes the rendered contents of a single document into
+// outputDir, creating any directories needed for nested documents
+func WriteDocument(conf *config.Config, outputDir string, doc *document.Document) error {
+	fileName := filepath.Join(
+		outputDir,
+		filepath.FromSlash(conf.Routing.RoutePrefix),
+		filepath.FromSlash(fmt.Sprintf("%v%v", doc.DocumentName, conf.Routing.UrlFileSuffix)),
+	)
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", fileName, err.Error())
+	}
+
+	err = ioutil.WriteFile(fileName, []byte(doc.FileContents), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err.Error())
+	}
+
+	return nil
+}
+
+// WriteGenerated writes a generated file, such as a feed, into outputDir
+func WriteGenerated(conf *config.Config, outputDir string, generated *site.Generated) error {
+	fileName := filepath.Join(
+		outputDir,
+		filepath.FromSlash(conf.Routing.RoutePrefix),
+		filepath.FromSlash(generated.Path),
+	)
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", fileName, err.Error())
+	}
+
+	err = ioutil.WriteFile(fileName, []byte(generated.Content), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %v: %v", fileName, err.Error())
+	}
+
+	return nil
+}
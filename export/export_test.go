@@ -0,0 +1,98 @@
+package export
+
+import (
+	"lightsites/config"
+
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExport(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	outputDir := t.TempDir()
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/export-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Assets = "../tests/assets"
+
+	require.NoError(Export(&conf, outputDir))
+
+	tests := []struct {
+		TestName     string
+		FileName     string
+		ExpectSubstr string
+	}{
+		{"Export writes top-level document", "content/index.html", "<title>Home</title>"},
+		{"Export writes nested document", "content/blog/post.html", "<title>Post</title>"},
+		{"Export copies assets", "assets/test.css", "margin: 0;"},
+	}
+
+	for _, test := range tests {
+		actual, err := ioutil.ReadFile(filepath.Join(outputDir, filepath.FromSlash(test.FileName)))
+		require.NoError(err, test.TestName)
+		assert.True(strings.Contains(string(actual), test.ExpectSubstr), test.TestName)
+	}
+}
+
+// TestExportFeeds validates that generated feeds and the sitemap are
+// exported next to the documents, and robots.txt at the root
+func TestExportFeeds(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	outputDir := t.TempDir()
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/feed-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Assets = "../tests/assets"
+	conf.BaseURL = "https://example.com"
+	conf.Feeds = []config.FeedConfig{{Path: "blog/index.xml", Directory: "blog"}}
+	conf.Sitemap.Enabled = true
+	conf.Robots.Enabled = true
+
+	require.NoError(Export(&conf, outputDir))
+
+	actual, err := ioutil.ReadFile(filepath.Join(outputDir, "content", "blog", "index.xml"))
+	require.NoError(err)
+	assert.Contains(string(actual), "<link>https://example.com/content/blog/first.html</link>")
+
+	actual, err = ioutil.ReadFile(filepath.Join(outputDir, "content", "sitemap.xml"))
+	require.NoError(err)
+	assert.Contains(string(actual), "<loc>https://example.com/content/blog/first.html</loc>")
+
+	actual, err = ioutil.ReadFile(filepath.Join(outputDir, "robots.txt"))
+	require.NoError(err)
+	assert.Contains(string(actual), "Sitemap: https://example.com/content/sitemap.xml")
+}
+
+// TestExportFailure validates that nothing is written when a document
+// fails to parse
+func TestExportFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	outputDir := t.TempDir()
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/walkstep"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Assets = "../tests/assets"
+
+	assert.Error(Export(&conf, outputDir))
+
+	files, err := ioutil.ReadDir(outputDir)
+	require.NoError(err)
+	assert.Len(files, 0)
+
+	conf.Directories.Documents = "../tests/does-not-exist"
+	assert.Error(Export(&conf, outputDir))
+}
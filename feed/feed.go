@@ -0,0 +1,309 @@
+package feed
+
+import (
+	"lightsites/constants"
+
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"time"
+)
+
+// Item is a document in a feed. All of its links are absolute.
+type Item struct {
+	Title   string
+	URL     string
+	Summary string
+	// Content is the rendered HTML of the document
+	Content string
+	// Published is the date of the document, and Updated is when it was
+	// last modified
+	Published time.Time
+	Updated   time.Time
+	Tags      []string
+}
+
+// Feed is a list of documents, newest first, that can be encoded as RSS,
+// Atom or JSON Feed
+type Feed struct {
+	Title       string
+	Description string
+	Author      string
+	// Link is the address of the site, and FeedURL the address of the feed
+	Link    string
+	FeedURL string
+	// Updated is the latest update of any item
+	Updated time.Time
+	Items   []Item
+}
+
+// Encode returns the feed in a format, along with the content type it is
+// served with
+func Encode(f Feed, format string) (content []byte, contentType string, err error) {
+	switch format {
+	case "", constants.FeedFormatRSS:
+		content, err = RSS(f)
+		return content, constants.RSSContentType, err
+	case constants.FeedFormatAtom:
+		content, err = Atom(f)
+		return content, constants.AtomContentType, err
+	case constants.FeedFormatJSON:
+		content, err = JSON(f)
+		return content, constants.JSONFeedContentType, err
+	}
+	return nil, "", fmt.Errorf("unknown feed format %v", format)
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	AtomLink      rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string      `xml:"title"`
+	Link        string      `xml:"link"`
+	GUID        rssGUID     `xml:"guid"`
+	PubDate     string      `xml:"pubDate,omitempty"`
+	Description string      `xml:"description,omitempty"`
+	Content     *cdataValue `xml:"content:encoded,omitempty"`
+	Categories  []string    `xml:"category"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type cdataValue struct {
+	Value string `xml:",cdata"`
+}
+
+// RSS encodes the feed as RSS 2.0, with the content of each item in
+// content:encoded
+func RSS(f Feed) ([]byte, error) {
+	channel := rssChannel{
+		Title:       f.Title,
+		Link:        f.Link,
+		Description: f.Description,
+		AtomLink:    rssLink{Href: f.FeedURL, Rel: "self", Type: constants.RSSContentType},
+		Items:       []rssItem{},
+	}
+	if !f.Updated.IsZero() {
+		channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, item := range f.Items {
+		entry := rssItem{
+			Title:       item.Title,
+			Link:        item.URL,
+			GUID:        rssGUID{IsPermaLink: true, Value: item.URL},
+			Description: item.Summary,
+			Categories:  item.Tags,
+		}
+		if !item.Published.IsZero() {
+			entry.PubDate = item.Published.UTC().Format(time.RFC1123Z)
+		}
+		if item.Content != "" {
+			entry.Content = &cdataValue{Value: item.Content}
+		}
+		channel.Items = append(channel.Items, entry)
+	}
+
+	return encodeXML(rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel:   channel,
+	})
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	ID       string      `xml:"id"`
+	Updated  string      `xml:"updated"`
+	Author   *atomAuthor `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Link       atomLink       `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Summary    string         `xml:"summary,omitempty"`
+	Content    *atomContent   `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Atom encodes the feed as Atom. Entries without a modification time use
+// their date as the time they were updated.
+func Atom(f Feed) ([]byte, error) {
+	feed := atomFeed{
+		Title:    f.Title,
+		Subtitle: f.Description,
+		ID:       f.Link,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.Link},
+			{Href: f.FeedURL, Rel: "self", Type: constants.AtomContentType},
+		},
+		Entries: []atomEntry{},
+	}
+	if f.Author != "" {
+		feed.Author = &atomAuthor{Name: f.Author}
+	}
+
+	for _, item := range f.Items {
+		updated := item.Updated
+		if updated.IsZero() {
+			updated = item.Published
+		}
+		entry := atomEntry{
+			Title:   item.Title,
+			ID:      item.URL,
+			Link:    atomLink{Href: item.URL},
+			Updated: updated.UTC().Format(time.RFC3339),
+			Summary: item.Summary,
+		}
+		if !item.Published.IsZero() {
+			entry.Published = item.Published.UTC().Format(time.RFC3339)
+		}
+		if item.Content != "" {
+			entry.Content = &atomContent{Type: "html", Value: item.Content}
+		}
+		for _, tag := range item.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	return encodeXML(feed)
+}
+
+// encodeXML encodes v as an indented XML document
+func encodeXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode feed: %v", err.Error())
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+type jsonFeed struct {
+	Version     string       `json:"version"`
+	Title       string       `json:"title"`
+	HomePageURL string       `json:"home_page_url"`
+	FeedURL     string       `json:"feed_url"`
+	Description string       `json:"description,omitempty"`
+	Authors     []jsonAuthor `json:"authors,omitempty"`
+	Items       []jsonItem   `json:"items"`
+}
+
+type jsonAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonItem struct {
+	ID            string     `json:"id"`
+	URL           string     `json:"url"`
+	Title         string     `json:"title"`
+	ContentHTML   string     `json:"content_html"`
+	Summary       string     `json:"summary,omitempty"`
+	DatePublished *time.Time `json:"date_published,omitempty"`
+	DateModified  *time.Time `json:"date_modified,omitempty"`
+	Tags          []string   `json:"tags,omitempty"`
+}
+
+// JSON encodes the feed as JSON Feed 1.1
+func JSON(f Feed) ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.Link,
+		FeedURL:     f.FeedURL,
+		Description: f.Description,
+		Items:       []jsonItem{},
+	}
+	if f.Author != "" {
+		feed.Authors = []jsonAuthor{{Name: f.Author}}
+	}
+
+	for _, item := range f.Items {
+		entry := jsonItem{
+			ID:          item.URL,
+			URL:         item.URL,
+			Title:       item.Title,
+			ContentHTML: item.Content,
+			Summary:     item.Summary,
+			Tags:        item.Tags,
+		}
+		if !item.Published.IsZero() {
+			published := item.Published.UTC()
+			entry.DatePublished = &published
+		}
+		if !item.Updated.IsZero() {
+			updated := item.Updated.UTC()
+			entry.DateModified = &updated
+		}
+		feed.Items = append(feed.Items, entry)
+	}
+
+	// the content is HTML, so escaping it again would only make it harder
+	// to read
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(feed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode feed: %v", err.Error())
+	}
+	return buf.Bytes(), nil
+}
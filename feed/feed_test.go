@@ -0,0 +1,128 @@
+package feed
+
+import (
+	"lightsites/constants"
+
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	f := Feed{
+		Title:       "Blog",
+		Description: "Posts & notes",
+		Author:      "Jane",
+		Link:        "https://example.com/",
+		FeedURL:     "https://example.com/blog/index.xml",
+		Updated:     time.Date(2021, 5, 2, 10, 0, 0, 0, time.UTC),
+		Items: []Item{
+			{
+				Title:     "Second",
+				URL:       "https://example.com/blog/second.html",
+				Summary:   "The second post",
+				Content:   "<p>The <em>second</em> post</p>",
+				Published: time.Date(2021, 5, 1, 0, 0, 0, 0, time.UTC),
+				Updated:   time.Date(2021, 5, 2, 10, 0, 0, 0, time.UTC),
+				Tags:      []string{"go"},
+			},
+			{
+				Title:     "First",
+				URL:       "https://example.com/blog/first.html",
+				Published: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	tests := []struct {
+		TestName          string
+		InputFormat       string
+		ExpectContentType string
+		ExpectSubstrs     []string
+	}{
+		{
+			"Encode RSS",
+			constants.FeedFormatRSS,
+			constants.RSSContentType,
+			[]string{
+				`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:content="http://purl.org/rss/1.0/modules/content/">`,
+				`<description>Posts &amp; notes</description>`,
+				`<lastBuildDate>Sun, 02 May 2021 10:00:00 +0000</lastBuildDate>`,
+				`<atom:link href="https://example.com/blog/index.xml" rel="self" type="application/rss+xml"></atom:link>`,
+				`<guid isPermaLink="true">https://example.com/blog/second.html</guid>`,
+				`<pubDate>Sat, 01 May 2021 00:00:00 +0000</pubDate>`,
+				`<content:encoded><![CDATA[<p>The <em>second</em> post</p>]]></content:encoded>`,
+				`<category>go</category>`,
+			},
+		},
+		{
+			"Encode RSS by default",
+			"",
+			constants.RSSContentType,
+			[]string{`<rss version="2.0"`},
+		},
+		{
+			"Encode Atom",
+			constants.FeedFormatAtom,
+			constants.AtomContentType,
+			[]string{
+				`<feed xmlns="http://www.w3.org/2005/Atom">`,
+				`<updated>2021-05-02T10:00:00Z</updated>`,
+				`<author>`,
+				`<link href="https://example.com/blog/index.xml" rel="self" type="application/atom+xml"></link>`,
+				`<published>2021-05-01T00:00:00Z</published>`,
+				`<content type="html">&lt;p&gt;The &lt;em&gt;second&lt;/em&gt; post&lt;/p&gt;</content>`,
+				`<category term="go"></category>`,
+				// entries without a modification time were updated when published
+				`<updated>2021-03-01T00:00:00Z</updated>`,
+			},
+		},
+		{
+			"Encode JSON Feed",
+			constants.FeedFormatJSON,
+			constants.JSONFeedContentType,
+			[]string{
+				`"version": "https://jsonfeed.org/version/1.1"`,
+				`"feed_url": "https://example.com/blog/index.xml"`,
+				`"content_html": "<p>The <em>second</em> post</p>"`,
+				`"date_published": "2021-05-01T00:00:00Z"`,
+				`"date_modified": "2021-05-02T10:00:00Z"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		content, contentType, err := Encode(f, test.InputFormat)
+		require.NoError(err, test.TestName)
+		assert.Equal(test.ExpectContentType, contentType, test.TestName)
+		for _, substr := range test.ExpectSubstrs {
+			assert.Contains(string(content), substr, test.TestName)
+		}
+
+		// the output must be well-formed
+		var parsed interface{}
+		if test.InputFormat == constants.FeedFormatJSON {
+			assert.NoError(json.Unmarshal(content, &parsed), test.TestName)
+		} else {
+			decoder := xml.NewDecoder(strings.NewReader(string(content)))
+			for {
+				_, err := decoder.Token()
+				if err != nil {
+					assert.Equal("EOF", err.Error(), test.TestName)
+					break
+				}
+			}
+		}
+	}
+
+	_, _, err := Encode(f, "rdf")
+	assert.EqualError(err, "unknown feed format rdf")
+}
@@ -3,6 +3,8 @@ module lightsites
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.4.1
+	github.com/andybalholm/brotli v1.0.0
 	github.com/gomarkdown/markdown v0.0.0-20200824053859-8c8b3816f167
 	github.com/stretchr/testify v1.6.1
 	golang.org/x/net v0.0.0-20201010224723-4f7140c49acb
@@ -1,16 +1,27 @@
 package handlers
 
 import (
+	"lightsites/compression"
 	"lightsites/config"
-	"lightsites/document"
+	"lightsites/constants"
+	"lightsites/site"
 
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
+	"time"
 )
 
-func ContentHandler(w http.ResponseWriter, req *http.Request, documents *[]document.Document, conf *config.Config) {
+// ContentHandler serves a rendered document from the provided site
+// snapshot. The snapshot should be loaded once per request so that the
+// whole response is served from a single consistent generation.
+func ContentHandler(w http.ResponseWriter, req *http.Request, s *site.Site) {
 	// w.Header().Set("Access-Control-Allow-Origin", "*")
 	// w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
 	// w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
@@ -22,29 +33,288 @@ func ContentHandler(w http.ResponseWriter, req *http.Request, documents *[]docum
 		return
 	}
 
-	documentName := strings.TrimPrefix(req.URL.Path, fmt.Sprintf("%v", conf.Routing.RoutePrefix))
+	// nothing has been loaded yet, so whether the document exists is unknown
+	if s == nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		log.Printf("%v transferred 0 bytes (no site loaded)", req.URL.Path)
+		return
+	}
 
-	if documentName == "" {
-		documentName = fmt.Sprintf("index%v", conf.Routing.UrlFileSuffix)
+	w.Header().Set(constants.GenerationHeader, fmt.Sprintf("%v", s.Generation))
+
+	// the route table also maps the bare route prefix to the index document
+	documentName := strings.TrimPrefix(req.URL.Path, s.Config.Routing.RoutePrefix)
+
+	document, ok := s.Lookup(documentName)
+	if ok {
+		if document.Stale {
+			w.Header().Set(constants.StaleHeader, "true")
+		}
+		w.Header().Set("Content-Type", "text/html")
+		setCacheControl(w, req, s.Config)
+
+		// pick one of the precompressed variants of the document, if the
+		// client accepts any of them
+		contents := document.FileContents
+		encodings := compression.Encodings(s.Config)
+		if len(encodings) > 0 {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+		available := []string{}
+		for _, encoding := range encodings {
+			_, ok := document.Encodings[encoding]
+			if ok {
+				available = append(available, encoding)
+			}
+		}
+		encoding := compression.Negotiate(req.Header.Get("Accept-Encoding"), available)
+		if encoding != "" {
+			contents = document.Encodings[encoding]
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		w.Header().Set("ETag", compression.VariantETag(document.ETag, encoding))
+
+		// ServeContent answers conditional requests (If-None-Match and
+		// If-Modified-Since) with 304 Not Modified, as well as HEAD and
+		// range requests
+		counter := &countingWriter{ResponseWriter: w}
+		http.ServeContent(counter, req, documentName, document.DateModified, strings.NewReader(contents))
+		log.Printf("%v transferred %v bytes (generation %v)", req.URL.Path, counter.written, s.Generation)
+		return
+	}
+
+	// feeds and the sitemap are generated from all of the documents
+	generated, ok := s.LookupGenerated(documentName)
+	if ok {
+		w.Header().Set("Content-Type", generated.ContentType)
+		w.Header().Set("ETag", generated.ETag)
+		setCacheControl(w, req, s.Config)
+
+		counter := &countingWriter{ResponseWriter: w}
+		http.ServeContent(counter, req, documentName, generated.DateModified, strings.NewReader(generated.Content))
+		log.Printf("%v transferred %v bytes (generation %v)", req.URL.Path, counter.written, s.Generation)
+		return
 	}
 
-	for _, document := range *documents {
-		if fmt.Sprintf("%v%v", document.DocumentName, conf.Routing.UrlFileSuffix) == documentName {
+	// the document exists, but failed to render and there is nothing else
+	// to serve in its place
+	file, failed := s.LookupFailure(documentName)
+	if failed {
+		status := s.Config.FailedDocuments.Status
+		if status == 0 {
+			status = constants.DefaultFailedDocumentStatus
+		}
+		body := s.ErrorPage
+		if body == "" {
+			w.Header().Set("Content-Type", "text/plain")
+			body = http.StatusText(status)
+		} else {
 			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusOK)
-			result, err := w.Write([]byte(document.FileContents))
-			if err != nil {
-				log.Printf("failed to write http response: %v", err.Error())
-			}
-			log.Printf("%v transferred %v bytes", req.URL.Path, result)
-			return
 		}
+		w.WriteHeader(status)
+		result, err := io.WriteString(w, body)
+		if err != nil {
+			log.Printf("failed to write http response: %v", err.Error())
+		}
+		log.Printf("%v transferred %v bytes (generation %v, document %v failed to render)", req.URL.Path, result, s.Generation, file)
+		return
 	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusNotFound)
 	result, err := w.Write([]byte{})
 	if err != nil {
 		log.Printf("failed to write http response: %v", err.Error())
 	}
-	log.Printf("%v transferred %v bytes", req.URL.Path, result)
+	log.Printf("%v transferred %v bytes (generation %v)", req.URL.Path, result, s.Generation)
+}
+
+// RobotsHandler serves robots.txt from the provided site snapshot
+func RobotsHandler(w http.ResponseWriter, req *http.Request, s *site.Site) {
+	if s == nil || s.Robots == nil {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", s.Robots.ContentType)
+	w.Header().Set("ETag", s.Robots.ETag)
+	setCacheControl(w, req, s.Config)
+	http.ServeContent(w, req, constants.RobotsPath, s.Robots.DateModified, strings.NewReader(s.Robots.Content))
+}
+
+// AssetsHandler serves the files in the assets directory under the assets
+// prefix. http.FileServer already answers conditional requests based on the
+// modification times of the files. Compressible files are served compressed
+// when the client accepts it, from a precompressed sibling file if there is
+// one and from an in-memory cache otherwise.
+func AssetsHandler(conf *config.Config) http.Handler {
+	fs := http.StripPrefix(conf.Routing.AssetsPrefix, http.FileServer(http.Dir(conf.Directories.Assets)))
+	cache := &compression.AssetCache{}
+	encodings := compression.Encodings(conf)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		setCacheControl(w, req, conf)
+
+		name := strings.TrimPrefix(req.URL.Path, conf.Routing.AssetsPrefix)
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if len(encodings) == 0 || !compression.Compressible(name) || contentType == "" {
+			fs.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := compression.Negotiate(req.Header.Get("Accept-Encoding"), encodings)
+		if encoding == "" {
+			fs.ServeHTTP(w, req)
+			return
+		}
+
+		// cleaning the name as an absolute path keeps it inside the assets
+		// directory
+		file := path.Join(conf.Directories.Assets, path.Clean("/"+name))
+		content, modTime, ok, err := cache.Get(conf, file, encoding)
+		if err != nil {
+			log.Printf("failed to compress asset %v, serving it uncompressed: %v", file, err.Error())
+		}
+		if !ok {
+			fs.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", encoding)
+		http.ServeContent(w, req, name, modTime, bytes.NewReader(content))
+	})
+}
+
+// HeadersHandler sets the configured response headers, such as the content
+// security policy, before calling the wrapped handler
+func HeadersHandler(conf *config.Config, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for key, value := range conf.GetHeaders(req.URL.Path) {
+			if value == "" {
+				w.Header().Del(key)
+				continue
+			}
+			w.Header().Set(key, value)
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// setCacheControl sets the Cache-Control header configured for the request
+// path, if there is one
+func setCacheControl(w http.ResponseWriter, req *http.Request, conf *config.Config) {
+	cacheControl := conf.GetCacheControl(req.URL.Path)
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+}
+
+// countingWriter counts the bytes written to a response, for logging
+type countingWriter struct {
+	http.ResponseWriter
+	written int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += n
+	return n, err
+}
+
+// StatusResponse is the body of the status endpoint
+type StatusResponse struct {
+	site.Report
+	Health site.Health `json:"health"`
+}
+
+// StatusHandler reports the state of the currently published site snapshot
+// and the health of the store as JSON, including every document that failed
+// to render. It responds with 503 until a snapshot has been published.
+func StatusHandler(w http.ResponseWriter, req *http.Request, store *site.Store) {
+	response := StatusResponse{
+		Report: site.Report{Failures: []site.Failure{}, Violations: []site.DocumentViolations{}},
+		Health: store.Health(),
+	}
+	status := http.StatusServiceUnavailable
+	s := store.Load()
+	if s != nil {
+		response.Report = s.Report()
+		status = http.StatusOK
+	}
+
+	writeJSON(w, status, response)
+}
+
+// HealthResponse is the body of the liveness and readiness endpoints
+type HealthResponse struct {
+	State string `json:"state"`
+	// Ready is set once the site has been loaded for the first time
+	Ready           bool      `json:"ready"`
+	Generation      uint64    `json:"generation"`
+	Documents       int       `json:"documents"`
+	FailedDocuments int       `json:"failedDocuments"`
+	LastRefresh     time.Time `json:"lastRefresh"`
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// getHealthResponse summarizes the store for the health endpoints
+func getHealthResponse(store *site.Store) HealthResponse {
+	health := store.Health()
+	response := HealthResponse{
+		State:       health.State,
+		LastRefresh: health.LastSuccess,
+		LastError:   health.LastError,
+	}
+
+	s := store.Load()
+	if s != nil {
+		response.Ready = true
+		response.Generation = s.Generation
+		response.Documents = len(s.Documents)
+		response.FailedDocuments = len(s.Errors)
+	}
+
+	return response
+}
+
+// writeJSON writes body as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("failed to marshal response: %v", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err = w.Write(content)
+	if err != nil {
+		log.Printf("failed to write http response: %v", err.Error())
+	}
+}
+
+// LivenessHandler always responds with 200 while the process is able to
+// serve requests. A degraded store is still live, since it keeps serving
+// the previously loaded documents.
+func LivenessHandler(w http.ResponseWriter, req *http.Request, store *site.Store) {
+	writeJSON(w, http.StatusOK, getHealthResponse(store))
+}
+
+// ReadinessHandler responds with 200 once the site has been loaded for the
+// first time, and 503 before then, so that no traffic is routed to an
+// instance that would answer every request with 503
+func ReadinessHandler(w http.ResponseWriter, req *http.Request, store *site.Store) {
+	response := getHealthResponse(store)
+	status := http.StatusServiceUnavailable
+	if response.Ready {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, response)
 }
@@ -0,0 +1,595 @@
+package handlers
+
+import (
+	"lightsites/compression"
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/helpers"
+	"lightsites/metrics"
+	"lightsites/site"
+	"lightsites/watcher"
+
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	s, err := site.Load(&conf, 3, nil)
+	require.NoError(err)
+
+	tests := []struct {
+		TestName     string
+		InputSite    *site.Site
+		InputMethod  string
+		InputPath    string
+		ExpectStatus int
+		ExpectSubstr string
+	}{
+		{"ContentHandler document", s, http.MethodGet, "/content/index.html", http.StatusOK, "<title>Home</title>"},
+		{"ContentHandler route prefix serves index", s, http.MethodGet, "/content/", http.StatusOK, "<title>Home</title>"},
+		{"ContentHandler alias", s, http.MethodGet, "/content/old-post.html", http.StatusOK, "<title>Post</title>"},
+		{"ContentHandler missing document", s, http.MethodGet, "/content/missing.html", http.StatusNotFound, ""},
+		{"ContentHandler options", s, http.MethodOptions, "/content/index.html", http.StatusOK, ""},
+		{"ContentHandler no site loaded", nil, http.MethodGet, "/content/index.html", http.StatusServiceUnavailable, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.InputMethod, test.InputPath, nil)
+		ContentHandler(w, req, test.InputSite)
+
+		assert.Equal(test.ExpectStatus, w.Code, test.TestName)
+		assert.True(strings.Contains(w.Body.String(), test.ExpectSubstr), test.TestName)
+		if test.InputSite != nil && test.InputMethod == http.MethodGet {
+			assert.Equal("3", w.Header().Get(constants.GenerationHeader), test.TestName)
+		}
+	}
+}
+
+// TestContentHandlerFailedDocuments validates what is served for documents
+// that failed to render
+func TestContentHandlerFailedDocuments(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/does-not-exist"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+
+	// the post uses a template, so it fails without a templates directory
+	w := httptest.NewRecorder()
+	ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/blog/post.html", nil), s)
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	assert.Equal(http.StatusText(http.StatusServiceUnavailable), w.Body.String())
+
+	errorPageConf := conf
+	errorPageConf.FailedDocuments.Status = http.StatusInternalServerError
+	errorPageConf.FailedDocuments.ErrorPage = "../tests/templates/alert.html"
+	s, err = site.Load(&errorPageConf, 1, nil)
+	require.NoError(err)
+
+	w = httptest.NewRecorder()
+	ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/blog/post.html", nil), s)
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("text/html", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "alert")
+
+	// a stale document is served with a header marking it as such
+	s.Documents[0].Stale = true
+	w = httptest.NewRecorder()
+	ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/"+s.Documents[0].DocumentName+".html", nil), s)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("true", w.Header().Get(constants.StaleHeader))
+}
+
+func TestStatusHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/does-not-exist"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	store := site.Store{}
+
+	w := httptest.NewRecorder()
+	StatusHandler(w, httptest.NewRequest(http.MethodGet, "/status", nil), &store)
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+
+	_, err := store.Refresh(&conf)
+	require.NoError(err)
+
+	w = httptest.NewRecorder()
+	StatusHandler(w, httptest.NewRequest(http.MethodGet, "/status", nil), &store)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+
+	response := StatusResponse{}
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(uint64(1), response.Generation)
+	assert.Equal(1, response.Documents)
+	require.Len(response.Failures, 1)
+	assert.Equal("blog/post", response.Failures[0].File)
+	assert.False(response.Failures[0].Stale)
+	assert.Equal(constants.HealthStateOK, response.Health.State)
+
+	// a failed refresh keeps the previous snapshot, but degrades the health
+	badConf := conf
+	badConf.Directories.Documents = "../tests/does-not-exist"
+	_, err = store.Refresh(&badConf)
+	require.Error(err)
+
+	w = httptest.NewRecorder()
+	StatusHandler(w, httptest.NewRequest(http.MethodGet, "/status", nil), &store)
+	assert.Equal(http.StatusOK, w.Code)
+	response = StatusResponse{}
+	require.NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(uint64(1), response.Generation)
+	assert.Equal(constants.HealthStateDegraded, response.Health.State)
+	assert.Equal("../tests/does-not-exist", response.Health.FailedPath)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/does-not-exist"
+
+	store := site.Store{}
+
+	tests := []struct {
+		TestName        string
+		InputDocuments  string
+		ExpectLiveness  int
+		ExpectReadiness int
+		ExpectState     string
+		ExpectDocuments int
+	}{
+		{"Health before loading", "", http.StatusOK, http.StatusServiceUnavailable, constants.HealthStateLoading, 0},
+		{"Health after failed first load", "../tests/does-not-exist", http.StatusOK, http.StatusServiceUnavailable, constants.HealthStateDegraded, 0},
+		{"Health after successful load", "../tests/export-docs", http.StatusOK, http.StatusOK, constants.HealthStateOK, 2},
+		{"Health after failed reload", "../tests/does-not-exist", http.StatusOK, http.StatusOK, constants.HealthStateDegraded, 2},
+	}
+
+	for _, test := range tests {
+		if test.InputDocuments != "" {
+			testConf := conf
+			testConf.Directories.Documents = test.InputDocuments
+			_, _ = store.Refresh(&testConf)
+		}
+
+		w := httptest.NewRecorder()
+		LivenessHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil), &store)
+		assert.Equal(test.ExpectLiveness, w.Code, test.TestName)
+
+		w = httptest.NewRecorder()
+		ReadinessHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil), &store)
+		assert.Equal(test.ExpectReadiness, w.Code, test.TestName)
+		assert.Equal("application/json", w.Header().Get("Content-Type"), test.TestName)
+
+		response := HealthResponse{}
+		require.NoError(json.Unmarshal(w.Body.Bytes(), &response), test.TestName)
+		assert.Equal(test.ExpectState, response.State, test.TestName)
+		assert.Equal(test.ExpectReadiness == http.StatusOK, response.Ready, test.TestName)
+		assert.Equal(test.ExpectDocuments, response.Documents, test.TestName)
+		if test.ExpectState == constants.HealthStateDegraded {
+			assert.NotEmpty(response.LastError, test.TestName)
+		}
+	}
+}
+
+// TestMetrics scrapes the metrics endpoint after serving a few documents, the
+// same way the handlers are registered in main
+func TestMetrics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	store := site.Store{}
+	_, err := store.Refresh(&conf)
+	require.NoError(err)
+
+	mux := http.NewServeMux()
+	mux.Handle(conf.Routing.RoutePrefix, metrics.Instrument(constants.RouteLabelContent, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ContentHandler(w, req, store.Load())
+	})))
+	mux.Handle(conf.Routing.MetricsPath, metrics.Instrument(constants.RouteLabelMetrics, http.HandlerFunc(metrics.Default.Handler)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, path := range []string{"/content/index.html", "/content/blog/post.html", "/content/missing.html"} {
+		resp, err := http.Get(server.URL + path)
+		require.NoError(err)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(server.URL + conf.Routing.MetricsPath)
+	require.NoError(err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(err)
+
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.True(strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4"))
+
+	expected := []string{
+		"# TYPE lightsites_http_requests_total counter",
+		`lightsites_http_requests_total{route="content",status="200"} `,
+		`lightsites_http_requests_total{route="content",status="404"} `,
+		"# TYPE lightsites_http_request_duration_seconds histogram",
+		`lightsites_http_request_duration_seconds_bucket{route="content",le="+Inf"} `,
+		`lightsites_http_response_size_bytes_count{route="content"} `,
+		`lightsites_refresh_duration_seconds_count{kind="full"} `,
+		`lightsites_document_render_duration_seconds_count{document="index"} `,
+		`lightsites_document_render_duration_seconds_count{document="blog/post"} `,
+		"lightsites_documents 2\n",
+		"lightsites_failed_documents 0\n",
+		`lightsites_template_reads_total{template="alert.html"} `,
+	}
+	for _, line := range expected {
+		assert.Contains(string(body), line)
+	}
+}
+
+// TestContentHandlerConditional validates that documents carry caching
+// headers and that conditional requests are answered with 304
+func TestContentHandlerConditional(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+	doc, ok := s.Lookup("index.html")
+	require.True(ok)
+	lastModified := doc.DateModified.UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		TestName     string
+		InputMethod  string
+		InputHeaders map[string]string
+		ExpectStatus int
+		ExpectBody   bool
+	}{
+		{"ContentHandler unconditional", http.MethodGet, map[string]string{}, http.StatusOK, true},
+		{"ContentHandler head", http.MethodHead, map[string]string{}, http.StatusOK, false},
+		{"ContentHandler matching etag", http.MethodGet, map[string]string{"If-None-Match": doc.ETag}, http.StatusNotModified, false},
+		{"ContentHandler one of several etags", http.MethodGet, map[string]string{"If-None-Match": `"other", ` + doc.ETag}, http.StatusNotModified, false},
+		{"ContentHandler different etag", http.MethodGet, map[string]string{"If-None-Match": `"other"`}, http.StatusOK, true},
+		{"ContentHandler not modified since", http.MethodGet, map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified, false},
+		{"ContentHandler modified since", http.MethodGet, map[string]string{"If-Modified-Since": "Mon, 01 Jan 2001 00:00:00 GMT"}, http.StatusOK, true},
+		{"ContentHandler etag takes precedence", http.MethodGet, map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": lastModified}, http.StatusOK, true},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(test.InputMethod, "/content/index.html", nil)
+		for key, value := range test.InputHeaders {
+			req.Header.Set(key, value)
+		}
+		ContentHandler(w, req, s)
+
+		assert.Equal(test.ExpectStatus, w.Code, test.TestName)
+		assert.Equal(test.ExpectBody, w.Body.Len() > 0, test.TestName)
+		assert.Equal(doc.ETag, w.Header().Get("ETag"), test.TestName)
+		assert.Equal("no-cache", w.Header().Get("Cache-Control"), test.TestName)
+		if test.ExpectStatus == http.StatusOK {
+			assert.Equal(lastModified, w.Header().Get("Last-Modified"), test.TestName)
+		}
+	}
+}
+
+// TestContentHandlerFeeds validates that generated feeds are served under
+// the route prefix with their content type
+func TestContentHandlerFeeds(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/feed-docs"
+	conf.BaseURL = "https://example.com"
+	conf.Feeds = []config.FeedConfig{{Path: "blog/feed.json", Format: constants.FeedFormatJSON, Directory: "blog"}}
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+	generated, ok := s.LookupGenerated("blog/feed.json")
+	require.True(ok)
+
+	w := httptest.NewRecorder()
+	ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/blog/feed.json", nil), s)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(constants.JSONFeedContentType, w.Header().Get("Content-Type"))
+	assert.Equal(generated.ETag, w.Header().Get("ETag"))
+	assert.Contains(w.Body.String(), `"url": "https://example.com/content/blog/second.html"`)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/content/blog/feed.json", nil)
+	req.Header.Set("If-None-Match", generated.ETag)
+	ContentHandler(w, req, s)
+	assert.Equal(http.StatusNotModified, w.Code)
+
+	w = httptest.NewRecorder()
+	ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/blog/index.xml", nil), s)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+// TestContentHandlerIndexModified validates that documents and feeds that
+// change because of other documents are no longer reported as not modified
+// since their previous Last-Modified time
+func TestContentHandlerIndexModified(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(helpers.CopyDirectory("../tests/index-docs", dir))
+	past := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, file := range []string{"index.md", "guide.md", "blog/post.md"} {
+		require.NoError(os.Chtimes(filepath.Join(dir, file), past, past))
+	}
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = dir
+	conf.BaseURL = "https://example.com"
+	conf.Feeds = []config.FeedConfig{{Path: "feed.json", Format: constants.FeedFormatJSON}}
+
+	lastModified := func(s *site.Site, path string) string {
+		w := httptest.NewRecorder()
+		ContentHandler(w, httptest.NewRequest(http.MethodGet, "/content/"+path, nil), s)
+		require.Equal(http.StatusOK, w.Code, path)
+		return w.Header().Get("Last-Modified")
+	}
+	modifiedSince := func(s *site.Site, path string, since string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/content/"+path, nil)
+		req.Header.Set("If-Modified-Since", since)
+		ContentHandler(w, req, s)
+		return w.Code
+	}
+
+	store := site.Store{}
+	s, err := store.Refresh(&conf)
+	require.NoError(err)
+	indexModified := lastModified(s, "index.html")
+	feedModified := lastModified(s, "feed.json")
+	assert.Equal(http.StatusNotModified, modifiedSince(s, "index.html", indexModified))
+
+	// only the title of the guide changes, which index.html lists
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "guide.md"), []byte("<attributes title=\"Handbook\"></attributes>\n"), 0644))
+	require.NoError(os.Chtimes(filepath.Join(dir, "guide.md"), past, past))
+	s, err = store.Apply(&conf, []watcher.Change{{Directory: dir, File: "guide.md", Op: watcher.Modified}})
+	require.NoError(err)
+	assert.Equal(http.StatusOK, modifiedSince(s, "index.html", indexModified))
+	assert.Equal(http.StatusOK, modifiedSince(s, "feed.json", feedModified))
+
+	// unchanged documents keep their Last-Modified time
+	guideModified := lastModified(s, "guide.html")
+	s, err = store.Refresh(&conf)
+	require.NoError(err)
+	assert.Equal(guideModified, lastModified(s, "guide.html"))
+
+	// deleting a document changes the feed, but leaves only older documents
+	feedModified = lastModified(s, "feed.json")
+	require.NoError(os.Remove(filepath.Join(dir, "blog/post.md")))
+	s, err = store.Apply(&conf, []watcher.Change{{Directory: dir, File: "blog/post.md", Op: watcher.Deleted}})
+	require.NoError(err)
+	assert.Equal(http.StatusOK, modifiedSince(s, "feed.json", feedModified))
+}
+
+func TestRobotsHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/feed-docs"
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+
+	// robots.txt is disabled by default
+	w := httptest.NewRecorder()
+	RobotsHandler(w, httptest.NewRequest(http.MethodGet, constants.RobotsPath, nil), s)
+	assert.Equal(http.StatusNotFound, w.Code)
+
+	conf.Robots = config.RobotsConfig{Enabled: true, DisallowHidden: true}
+	s, err = site.Load(&conf, 1, nil)
+	require.NoError(err)
+
+	w = httptest.NewRecorder()
+	RobotsHandler(w, httptest.NewRequest(http.MethodGet, constants.RobotsPath, nil), s)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal(constants.RobotsContentType, w.Header().Get("Content-Type"))
+	assert.Equal("User-agent: *\nDisallow: /content/.\nDisallow: /content/*/.\n", w.Body.String())
+
+	w = httptest.NewRecorder()
+	RobotsHandler(w, httptest.NewRequest(http.MethodGet, constants.RobotsPath, nil), nil)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestAssetsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Assets = "../tests/assets"
+
+	w := httptest.NewRecorder()
+	AssetsHandler(&conf).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/assets/test.css", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("public, max-age=3600", w.Header().Get("Cache-Control"))
+
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(lastModified)
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/assets/test.css", nil)
+	req.Header.Set("If-Modified-Since", lastModified)
+	AssetsHandler(&conf).ServeHTTP(w, req)
+	assert.Equal(http.StatusNotModified, w.Code)
+}
+
+// TestContentHandlerCompression validates that the precompressed variants
+// of a document are negotiated with Accept-Encoding
+func TestContentHandlerCompression(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+	conf.Compression.MinSize = 0
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+	doc, ok := s.Lookup("index.html")
+	require.True(ok)
+	require.Contains(doc.Encodings, constants.GzipEncoding)
+	require.Contains(doc.Encodings, constants.BrotliEncoding)
+
+	tests := []struct {
+		TestName       string
+		InputEncoding  string
+		ExpectEncoding string
+	}{
+		{"ContentHandler no encoding", "", ""},
+		{"ContentHandler gzip", "gzip", constants.GzipEncoding},
+		{"ContentHandler brotli preferred", "gzip, br", constants.BrotliEncoding},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/content/index.html", nil)
+		req.Header.Set("Accept-Encoding", test.InputEncoding)
+		ContentHandler(w, req, s)
+
+		assert.Equal(http.StatusOK, w.Code, test.TestName)
+		assert.Equal("Accept-Encoding", w.Header().Get("Vary"), test.TestName)
+		assert.Equal(test.ExpectEncoding, w.Header().Get("Content-Encoding"), test.TestName)
+		assert.Equal(compression.VariantETag(doc.ETag, test.ExpectEncoding), w.Header().Get("ETag"), test.TestName)
+		if test.ExpectEncoding == "" {
+			assert.Equal(doc.FileContents, w.Body.String(), test.TestName)
+		} else {
+			assert.Equal(doc.Encodings[test.ExpectEncoding], w.Body.String(), test.TestName)
+		}
+	}
+
+	// the variant's own ETag is used for conditional requests
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/content/index.html", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", compression.VariantETag(doc.ETag, constants.GzipEncoding))
+	ContentHandler(w, req, s)
+	assert.Equal(http.StatusNotModified, w.Code)
+}
+
+func TestAssetsHandlerCompression(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Assets = "../tests/assets"
+	conf.Compression.MinSize = 0
+
+	tests := []struct {
+		TestName       string
+		InputPath      string
+		InputEncoding  string
+		ExpectEncoding string
+	}{
+		{"AssetsHandler no encoding", "/assets/site.css", "", ""},
+		{"AssetsHandler gzip", "/assets/site.css", "gzip", constants.GzipEncoding},
+		{"AssetsHandler too small to compress", "/assets/test.css", "gzip", ""},
+		{"AssetsHandler traversal", "/assets/../config/config.go", "gzip", ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, test.InputPath, nil)
+		req.Header.Set("Accept-Encoding", test.InputEncoding)
+		AssetsHandler(&conf).ServeHTTP(w, req)
+
+		assert.Equal(test.ExpectEncoding, w.Header().Get("Content-Encoding"), test.TestName)
+		if test.ExpectEncoding != "" {
+			assert.Equal(http.StatusOK, w.Code, test.TestName)
+			assert.Equal("Accept-Encoding", w.Header().Get("Vary"), test.TestName)
+			assert.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/css"), test.TestName)
+		}
+	}
+}
+
+// TestHeadersHandler validates that the configured headers are set on both
+// documents and assets, the same way the handlers are registered in main
+func TestHeadersHandler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+	conf.Directories.Assets = "../tests/assets"
+	conf.Headers.OnionLocation = "http://example.onion"
+	conf.Headers.Routes = map[string]map[string]string{
+		"/assets/": {constants.CSPHeader: ""},
+	}
+
+	s, err := site.Load(&conf, 1, nil)
+	require.NoError(err)
+
+	content := HeadersHandler(&conf, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ContentHandler(w, req, s)
+	}))
+	assets := HeadersHandler(&conf, AssetsHandler(&conf))
+
+	tests := []struct {
+		TestName     string
+		InputHandler http.Handler
+		InputPath    string
+		ExpectStatus int
+		ExpectCSP    string
+	}{
+		{"HeadersHandler document", content, "/content/index.html", http.StatusOK, constants.DefaultCSP},
+		{"HeadersHandler missing document", content, "/content/missing.html", http.StatusNotFound, constants.DefaultCSP},
+		{"HeadersHandler asset", assets, "/assets/test.css", http.StatusOK, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		test.InputHandler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.InputPath, nil))
+
+		assert.Equal(test.ExpectStatus, w.Code, test.TestName)
+		assert.Equal(test.ExpectCSP, w.Header().Get(constants.CSPHeader), test.TestName)
+		assert.Equal(constants.DefaultReferrerPolicy, w.Header().Get(constants.ReferrerPolicyHeader), test.TestName)
+		assert.Equal("nosniff", w.Header().Get(constants.ContentTypeOptionsHeader), test.TestName)
+		assert.Equal(constants.DefaultPermissionsPolicy, w.Header().Get(constants.PermissionsPolicyHeader), test.TestName)
+		assert.Equal("", w.Header().Get(constants.HSTSHeader), test.TestName)
+		assert.Equal("http://example.onion"+test.InputPath, w.Header().Get(constants.OnionLocationHeader), test.TestName)
+	}
+}
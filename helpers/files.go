@@ -1,10 +1,13 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"lightsites/constants"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -41,9 +44,38 @@ func ReadDirectory(directory string) ([]os.FileInfo, error) {
 
 	files = append(files, fInfo...)
 
+	// Readdir returns entries in directory order, which differs between
+	// filesystems, so sort by name for deterministic results
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
 	return files, nil
 }
 
+// WalkError is returned when a path can't be read while walking a
+// directory, so that callers can tell which path failed
+type WalkError struct {
+	Path string
+	Err  error
+}
+
+func (err *WalkError) Error() string {
+	return fmt.Sprintf("err walking path %v: %v", err.Path, err.Err.Error())
+}
+
+func (err *WalkError) Unwrap() error {
+	return err.Err
+}
+
+// FailedPath returns the path that caused err, if err was caused by a
+// WalkError, or an empty string otherwise
+func FailedPath(err error) string {
+	var walkErr *WalkError
+	if errors.As(err, &walkErr) {
+		return walkErr.Path
+	}
+	return ""
+}
+
 type DirectoryListing struct {
 	Files []string
 	Path  string
@@ -51,7 +83,7 @@ type DirectoryListing struct {
 
 func (dirList *DirectoryListing) WalkStep(path string, f os.FileInfo, err error) error {
 	if err != nil {
-		return fmt.Errorf("err walking path %v: %v", path, err.Error())
+		return &WalkError{Path: path, Err: err}
 	}
 	pathTrimmed := strings.TrimPrefix(path, dirList.Path)
 	if pathTrimmed != "" {
@@ -66,7 +98,57 @@ func (dirList *DirectoryListing) WalkStep(path string, f os.FileInfo, err error)
 func (dirList *DirectoryListing) WalkDirectory() error {
 	err := filepath.Walk(dirList.Path, dirList.WalkStep)
 	if err != nil {
-		return fmt.Errorf("failed to walk dir %v: %v", dirList.Path, err.Error())
+		return fmt.Errorf("failed to walk dir %v: %w", dirList.Path, err)
+	}
+	return nil
+}
+
+// CopyFile copies the file at src to dst, creating any missing parent
+// directories of dst along the way
+func CopyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %v: %v", src, err.Error())
+	}
+	defer in.Close()
+
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for %v: %v", dst, err.Error())
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %v: %v", dst, err.Error())
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %v to %v: %v", src, dst, err.Error())
+	}
+
+	return out.Close()
+}
+
+// CopyDirectory recursively copies every file under src into dst,
+// preserving the relative directory structure
+func CopyDirectory(src string, dst string) error {
+	err := filepath.Walk(src, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return fmt.Errorf("err walking path %v: %v", path, err.Error())
+		}
+		if f.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("failed to determine relative path of %v: %v", path, err.Error())
+		}
+		return CopyFile(path, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy dir %v: %v", src, err.Error())
 	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 
@@ -96,3 +97,28 @@ func TestWalkDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestCopyDirectory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	outputDir := t.TempDir()
+
+	require.NoError(CopyDirectory("../tests/walkstep", outputDir))
+
+	dirList := DirectoryListing{Path: outputDir, Files: []string{}}
+	require.NoError(dirList.WalkDirectory())
+	assert.Equal([]string{"nested1/nestedtest1", "nested2/nestedtest2", "test1"}, dirList.Files)
+
+	assert.Error(CopyDirectory("../tests/does-not-exist", outputDir))
+}
+
+func TestFailedPath(t *testing.T) {
+	assert := assert.New(t)
+
+	dirList := DirectoryListing{Path: "../tests/does-not-exist", Files: []string{}}
+	err := dirList.WalkDirectory()
+	assert.Error(err)
+	assert.Equal("../tests/does-not-exist", FailedPath(err))
+	assert.Equal("", FailedPath(fmt.Errorf("unrelated")))
+}
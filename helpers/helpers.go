@@ -2,11 +2,14 @@ package helpers
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"math"
 	"regexp"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
@@ -34,6 +37,23 @@ func GetNodeOfType(docNode *html.Node, nodeType string) (node *html.Node) {
 	return f(docNode)
 }
 
+// GetNodesOfType retrieves every descendant node/element of nodeType from
+// the passed-in docNode, in document order
+func GetNodesOfType(docNode *html.Node, nodeType string) (nodes []*html.Node) {
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == nodeType {
+			nodes = append(nodes, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+
+	f(docNode)
+	return nodes
+}
+
 // Converts a string to a lowercase, hyphen-separated string of max length 36
 // Unused currently
 func GetTitleURLFromString(title string, maxLength int, lowerCase bool) (output string) {
@@ -78,3 +98,27 @@ func RenderNode(n *html.Node) (output string, err error) {
 	}
 	return buf.String(), nil
 }
+
+// Backoff returns how long to wait before retrying after the given number
+// of consecutive failed attempts (starting at 1). The wait doubles with every
+// attempt, starting at initial, and never exceeds max.
+func Backoff(attempt int, initial time.Duration, max time.Duration) time.Duration {
+	backoff := initial
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+		if backoff >= max {
+			return max
+		}
+	}
+	if backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// ETag returns a strong HTTP entity tag for the content, derived from its
+// sha256 hash
+func ETag(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return fmt.Sprintf(`"%v"`, hex.EncodeToString(sum[:16]))
+}
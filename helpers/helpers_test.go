@@ -4,6 +4,7 @@ import (
 	"lightsites/constants"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -46,6 +47,30 @@ func TestGetNodeOfType(t *testing.T) {
 	}
 }
 
+func TestGetNodesOfType(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName      string
+		InputHTML     string
+		ExpectedNodes []string
+	}{
+		{"GetNodesOfType none", `<p>text</p>`, nil},
+		{"GetNodesOfType nested", `<table id="a"><tr><td><table id="b"></table></td></tr></table><table id="c"></table>`, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		doc, err := html.Parse(strings.NewReader(test.InputHTML))
+		require.NoError(err, test.TestName)
+		var ids []string
+		for _, n := range GetNodesOfType(doc, constants.TableNode) {
+			ids = append(ids, n.Attr[0].Val)
+		}
+		assert.Equal(test.ExpectedNodes, ids, test.TestName)
+	}
+}
+
 func TestGetTitleURLFromString(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -105,3 +130,24 @@ func TestRenderNode(t *testing.T) {
 		assert.Equal(test.OutputHTML, actual, test.TestName)
 	}
 }
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		InputAttempt int
+		Expected     time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{1000, time.Minute},
+	}
+
+	for _, test := range tests {
+		assert.Equal(test.Expected, Backoff(test.InputAttempt, time.Second, time.Minute), test.InputAttempt)
+	}
+}
@@ -2,65 +2,288 @@ package main
 
 import (
 	"lightsites/config"
+	"lightsites/constants"
 	"lightsites/document"
+	"lightsites/export"
 	"lightsites/handlers"
 	"lightsites/helpers"
+	"lightsites/metrics"
+	"lightsites/site"
+	"lightsites/watcher"
 
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
-var documents []document.Document
-var documentDirectoryList helpers.DirectoryListing
-var globalConf *config.Config
+var store site.Store
 
 func contentHandler(w http.ResponseWriter, req *http.Request) {
-	handlers.ContentHandler(w, req, &documents, globalConf)
+	handlers.ContentHandler(w, req, store.Load())
 }
 
-func main() {
-	conf, err := config.LoadConfig()
+func robotsHandler(w http.ResponseWriter, req *http.Request) {
+	handlers.RobotsHandler(w, req, store.Load())
+}
+
+func statusHandler(w http.ResponseWriter, req *http.Request) {
+	handlers.StatusHandler(w, req, &store)
+}
+
+func livenessHandler(w http.ResponseWriter, req *http.Request) {
+	handlers.LivenessHandler(w, req, &store)
+}
+
+func readinessHandler(w http.ResponseWriter, req *http.Request) {
+	handlers.ReadinessHandler(w, req, &store)
+}
+
+// logFailures logs every document in the site that failed to render, and
+// every part of a document that breaks the no-JavaScript policy
+func logFailures(s *site.Site) {
+	s.LogViolations()
+	for _, failure := range s.Failures() {
+		if failure.Stale {
+			log.Printf("failed to process document %v (serving previous render): %v", failure.File, failure.Error)
+		} else {
+			log.Printf("failed to process document %v: %v", failure.File, failure.Error)
+		}
+	}
+}
+
+// build renders the whole site once and writes it to the output directory
+// instead of serving it
+func build(conf *config.Config, args []string) {
+	flags := flag.NewFlagSet(constants.BuildCommand, flag.ExitOnError)
+	outputDir := flags.String("o", constants.DefaultBuildDirectory, "directory to write the rendered site to")
+	_ = flags.Parse(args)
+
+	log.Printf("building site into %v...", *outputDir)
+	err := export.Export(conf, *outputDir)
 	if err != nil {
-		log.Fatalf("failed to process config: %v", err.Error())
+		log.Fatalf("failed to build site: %v", err.Error())
+	}
+	log.Printf("done building site into %v", *outputDir)
+}
+
+// deps prints the name of every document that uses the given template, so
+// that the impact of editing a shared template can be assessed beforehand
+func deps(conf *config.Config, args []string) {
+	flags := flag.NewFlagSet(constants.DepsCommand, flag.ExitOnError)
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatalf("usage: %v %v <template file>", os.Args[0], constants.DepsCommand)
+	}
+
+	s, err := site.Load(conf, 1, nil)
+	if err != nil {
+		log.Fatalf("failed to load site: %v", err.Error())
+	}
+
+	for _, file := range s.Dependents(flags.Arg(0)) {
+		fmt.Println(file)
+	}
+}
+
+// templates prints every template file along with the parameters it
+// declares, so that authors know which attributes a <template> tag takes
+func templates(conf *config.Config, args []string) {
+	flags := flag.NewFlagSet(constants.TemplatesCommand, flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	list, err := document.ListTemplates(conf)
+	if err != nil {
+		log.Fatalf("failed to list templates: %v", err.Error())
+	}
+
+	failed := 0
+	for _, template := range list {
+		fmt.Println(template.File)
+		if template.Error != "" {
+			failed++
+			fmt.Printf("  error: %v\n", template.Error)
+			continue
+		}
+		if len(template.Params) == 0 {
+			fmt.Println("  no declared parameters")
+		}
+		for _, param := range template.Params {
+			fmt.Printf("  %v\n", param)
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("%v of %v templates have invalid parameter declarations", failed, len(list))
+	}
+}
+
+// check renders every document and reports the ones that failed, exiting
+// with a non-zero status if there are any
+func check(conf *config.Config, args []string) {
+	flags := flag.NewFlagSet(constants.CheckCommand, flag.ExitOnError)
+	_ = flags.Parse(args)
+
+	s, err := site.Load(conf, 1, nil)
+	if err != nil {
+		log.Fatalf("failed to load site: %v", err.Error())
+	}
+
+	for _, document := range s.Violations() {
+		for _, violation := range document.Violations {
+			fmt.Printf("%v: warning: %v\n", document.File, violation)
+		}
 	}
 
-	globalConf = &conf
-
-	go func() {
-		for {
-			log.Print("reading directory...")
-			documentDirectoryList.Path = conf.Directories.Documents
-
-			documentDirectoryList.Files = []string{}
-			err := documentDirectoryList.WalkDirectory()
-			if err != nil {
-				log.Fatalf("failed to read directory %v: %v", conf.Directories.Documents, err.Error())
-			}
-
-			newDocuments := []document.Document{}
-			for _, file := range documentDirectoryList.Files {
-				_, err := document.ParseDocument(&conf, &newDocuments, &documentDirectoryList.Files, file)
-				if err != nil {
-					log.Printf("failed to process document %v: %v", file, err.Error())
-				}
-			}
-			documents = newDocuments
-			log.Printf("done reading directory. %v documents found. sleeping %v.", len(documents), conf.RefreshInterval)
-			time.Sleep(conf.RefreshInterval)
+	failures := s.Failures()
+	for _, failure := range failures {
+		fmt.Printf("%v: %v\n", failure.File, failure.Error)
+	}
+	if len(failures) > 0 {
+		log.Fatalf("%v of %v documents failed to render", len(failures), len(s.DirectoryListing.Files))
+	}
+	log.Printf("all %v documents rendered successfully", len(s.Documents))
+}
+
+// refresh periodically re-renders every document. With a refresh interval
+// of 0, the documents are only rendered once. If the documents can't be
+// loaded, the previous documents keep being served and loading is retried
+// with an exponential backoff.
+func refresh(conf *config.Config) {
+	initialBackoff := conf.Retry.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = constants.DefaultInitialBackoff
+	}
+	maxBackoff := conf.Retry.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = constants.DefaultMaxBackoff
+	}
+
+	for {
+		log.Print("reading directory...")
+		s, err := store.Refresh(conf)
+		if err != nil {
+			health := store.Health()
+			backoff := helpers.Backoff(health.ConsecutiveFailures, initialBackoff, maxBackoff)
+			log.Printf(
+				"failed to load site: state=%v path=%q attempt=%v retry_in=%v error=%q",
+				health.State,
+				health.FailedPath,
+				health.ConsecutiveFailures,
+				backoff,
+				err.Error(),
+			)
+			time.Sleep(backoff)
+			continue
+		}
+
+		logFailures(s)
+
+		if conf.RefreshInterval <= 0 {
+			log.Printf("done reading directory. generation %v published, %v documents found. periodic refresh disabled.", s.Generation, len(s.Documents))
+			return
+		}
+
+		log.Printf("done reading directory. generation %v published, %v documents found. sleeping %v.", s.Generation, len(s.Documents), conf.RefreshInterval)
+		time.Sleep(conf.RefreshInterval)
+	}
+}
+
+// watch polls the documents and templates directories and re-renders the
+// affected documents whenever something changes
+func watch(conf *config.Config) {
+	w := watcher.Watcher{
+		Directories: []string{conf.Directories.Documents, conf.Directories.Templates},
+		Interval:    conf.Watch.Interval,
+		Debounce:    conf.Watch.Debounce,
+	}
+
+	log.Printf("watching %v and %v for changes every %v", conf.Directories.Documents, conf.Directories.Templates, conf.Watch.Interval)
+	w.Watch(nil, func(changes []watcher.Change) {
+		for _, change := range changes {
+			log.Printf("%v/%v %v", change.Directory, change.File, change.Op)
 		}
-	}()
 
-	http.HandleFunc(fmt.Sprintf("%v", conf.Routing.RoutePrefix), contentHandler)
+		s, err := store.Apply(conf, changes)
+		if err != nil {
+			health := store.Health()
+			log.Printf(
+				"failed to apply changes: state=%v path=%q attempt=%v error=%q",
+				health.State,
+				health.FailedPath,
+				health.ConsecutiveFailures,
+				err.Error(),
+			)
+			return
+		}
+
+		logFailures(s)
+		log.Printf("done applying changes. generation %v published, %v documents found.", s.Generation, len(s.Documents))
+	})
+}
+
+func serve(conf *config.Config) {
+	go refresh(conf)
+
+	if conf.Watch.Enabled {
+		go watch(conf)
+	}
+
+	http.Handle(fmt.Sprintf("%v", conf.Routing.RoutePrefix), metrics.Instrument(constants.RouteLabelContent, handlers.HeadersHandler(conf, http.HandlerFunc(contentHandler))))
+	if conf.Robots.Enabled {
+		http.Handle(constants.RobotsPath, metrics.Instrument(constants.RouteLabelRobots, handlers.HeadersHandler(conf, http.HandlerFunc(robotsHandler))))
+	}
+	if conf.Routing.StatusPath != "" {
+		http.Handle(conf.Routing.StatusPath, metrics.Instrument(constants.RouteLabelStatus, http.HandlerFunc(statusHandler)))
+	}
+	if conf.Routing.MetricsPath != "" {
+		http.Handle(conf.Routing.MetricsPath, metrics.Instrument(constants.RouteLabelMetrics, http.HandlerFunc(metrics.Default.Handler)))
+	}
+	if conf.Health.LivenessPath != "" {
+		http.Handle(conf.Health.LivenessPath, metrics.Instrument(constants.RouteLabelLiveness, http.HandlerFunc(livenessHandler)))
+	}
+	if conf.Health.ReadinessPath != "" {
+		http.Handle(conf.Health.ReadinessPath, metrics.Instrument(constants.RouteLabelReadiness, http.HandlerFunc(readinessHandler)))
+	}
 
 	// serve static files
-	fs := http.FileServer(http.Dir(conf.Directories.Assets))
-	http.Handle(conf.Routing.AssetsPrefix, http.StripPrefix(conf.Routing.AssetsPrefix, fs))
+	http.Handle(conf.Routing.AssetsPrefix, metrics.Instrument(constants.RouteLabelAssets, handlers.HeadersHandler(conf, handlers.AssetsHandler(conf))))
 
 	log.Printf("begin listening on %v", conf.ListenAddr)
-	err = http.ListenAndServe(conf.ListenAddr, nil)
+	err := http.ListenAndServe(conf.ListenAddr, nil)
 	if err != nil {
 		log.Fatalf("failed to listen and serve: %v", err.Error())
 	}
 }
+
+func main() {
+	conf, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("failed to process config: %v", err.Error())
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		log.Fatalf("invalid config: %v", err.Error())
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case constants.BuildCommand:
+			build(&conf, os.Args[2:])
+		case constants.DepsCommand:
+			deps(&conf, os.Args[2:])
+		case constants.CheckCommand:
+			check(&conf, os.Args[2:])
+		case constants.TemplatesCommand:
+			templates(&conf, os.Args[2:])
+		default:
+			log.Fatalf("unknown command %v, expected one of: %v", os.Args[1], strings.Join(constants.Commands, ", "))
+		}
+		return
+	}
+
+	serve(&conf)
+}
@@ -0,0 +1,301 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metric types, as they appear in the exposition format
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// DurationBuckets are the default histogram buckets for durations in
+// seconds
+var DurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// SizeBuckets are the default histogram buckets for sizes in bytes
+var SizeBuckets = []float64{0, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304}
+
+// series holds the value(s) of a metric for one combination of label values
+type series struct {
+	labelValues []string
+	// value is used by counters and gauges
+	value float64
+	// buckets, sum and count are used by histograms. buckets holds the
+	// number of observations in each bucket, not the cumulative count.
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Metric is a counter, gauge or histogram with a fixed set of label names.
+// All methods are safe for concurrent use.
+type Metric struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	// upper bounds of the histogram buckets, excluding +Inf
+	bounds []float64
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+// Registry is a set of metrics that are exposed together
+type Registry struct {
+	mutex   sync.Mutex
+	metrics []*Metric
+}
+
+// Default is the registry that the metrics of this program are registered in
+var Default = &Registry{}
+
+func (registry *Registry) register(m *Metric) *Metric {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.metrics = append(registry.metrics, m)
+	return m
+}
+
+// NewCounter registers a counter, which can only increase
+func (registry *Registry) NewCounter(name string, help string, labelNames ...string) *Metric {
+	return registry.register(&Metric{name: name, help: help, metricType: counterType, labelNames: labelNames, series: make(map[string]*series)})
+}
+
+// NewGauge registers a gauge, which can be set to any value
+func (registry *Registry) NewGauge(name string, help string, labelNames ...string) *Metric {
+	return registry.register(&Metric{name: name, help: help, metricType: gaugeType, labelNames: labelNames, series: make(map[string]*series)})
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds,
+// which must be sorted in increasing order
+func (registry *Registry) NewHistogram(name string, help string, bounds []float64, labelNames ...string) *Metric {
+	return registry.register(&Metric{name: name, help: help, metricType: histogramType, labelNames: labelNames, bounds: bounds, series: make(map[string]*series)})
+}
+
+// get returns the series for the label values, creating it if necessary.
+// The caller must hold the mutex.
+func (m *Metric) get(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		log.Printf("metric %v expects %v label values, got %v", m.name, len(m.labelNames), len(labelValues))
+		labelValues = make([]string, len(m.labelNames))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if m.metricType == histogramType {
+			s.buckets = make([]uint64, len(m.bounds)+1)
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add increases a counter or gauge by delta
+func (m *Metric) Add(delta float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.get(labelValues).value += delta
+}
+
+// Inc increases a counter or gauge by 1
+func (m *Metric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Set sets a gauge to value
+func (m *Metric) Set(value float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.get(labelValues).value = value
+}
+
+// Observe records a value in a histogram
+func (m *Metric) Observe(value float64, labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s := m.get(labelValues)
+	i := sort.SearchFloat64s(m.bounds, value)
+	s.buckets[i]++
+	s.sum += value
+	s.count++
+}
+
+// ObserveDuration records the time elapsed since start, in seconds
+func (m *Metric) ObserveDuration(start time.Time, labelValues ...string) {
+	m.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Delete removes the series for the label values, if there is one
+func (m *Metric) Delete(labelValues ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.series, strings.Join(labelValues, "\xff"))
+}
+
+// Value returns the value of a counter or gauge, mainly for unit tests
+func (m *Metric) Value(labelValues ...string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.get(labelValues).value
+}
+
+// formatFloat formats a value the way Prometheus expects it
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// labelValueEscaper escapes label values for the exposition format
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// formatLabels renders a label set such as {route="content",status="200"}.
+// extraName and extraValue are appended when extraName is not empty, which
+// is used for the le label of histogram buckets.
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, name, labelValueEscaper.Replace(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%v="%v"`, extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("{%v}", strings.Join(pairs, ","))
+}
+
+// write renders the metric in the Prometheus text exposition format, with
+// its series sorted by label values
+func (m *Metric) write(w io.Writer) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	keys := []string{}
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	_, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", m.name, m.help, m.name, m.metricType)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.metricType != histogramType {
+			_, err = fmt.Fprintf(w, "%v%v %v\n", m.name, formatLabels(m.labelNames, s.labelValues, "", ""), formatFloat(s.value))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		cumulative := uint64(0)
+		for i, bound := range append(append([]float64{}, m.bounds...), math.Inf(1)) {
+			cumulative += s.buckets[i]
+			_, err = fmt.Fprintf(w, "%v_bucket%v %v\n", m.name, formatLabels(m.labelNames, s.labelValues, "le", formatFloat(bound)), cumulative)
+			if err != nil {
+				return err
+			}
+		}
+		labels := formatLabels(m.labelNames, s.labelValues, "", "")
+		_, err = fmt.Fprintf(w, "%v_sum%v %v\n%v_count%v %v\n", m.name, labels, formatFloat(s.sum), m.name, labels, s.count)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Write renders every metric in the registry in the Prometheus text
+// exposition format, sorted by name
+func (registry *Registry) Write(w io.Writer) error {
+	registry.mutex.Lock()
+	metrics := append([]*Metric{}, registry.metrics...)
+	registry.mutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	buf := bufio.NewWriter(w)
+	for _, m := range metrics {
+		err := m.write(buf)
+		if err != nil {
+			return fmt.Errorf("failed to write metric %v: %v", m.name, err.Error())
+		}
+	}
+	return buf.Flush()
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (registry *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	err := registry.Write(w)
+	if err != nil {
+		log.Printf("failed to write metrics: %v", err.Error())
+	}
+}
+
+// responseRecorder captures the status code and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+// Instrument wraps a handler so that the count, latency and size of its
+// responses are recorded under the given route label
+func Instrument(route string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w}
+		handler.ServeHTTP(recorder, req)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		Requests.Inc(route, strconv.Itoa(status))
+		RequestDuration.ObserveDuration(start, route)
+		ResponseSize.Observe(float64(recorder.size), route)
+	})
+}
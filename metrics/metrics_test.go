@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	registry := &Registry{}
+	counter := registry.NewCounter("test_requests_total", "Test requests.", "route", "status")
+	gauge := registry.NewGauge("test_documents", "Test documents.")
+	histogram := registry.NewHistogram("test_duration_seconds", "Test durations.", []float64{0.1, 1}, "route")
+
+	counter.Inc("content", "200")
+	counter.Add(2, "content", "200")
+	counter.Inc("content", "404")
+	counter.Inc(`say "hi"`+"\n", "500")
+	gauge.Set(7)
+	histogram.Observe(0.05, "content")
+	histogram.Observe(0.5, "content")
+	histogram.Observe(5, "content")
+	histogram.Observe(5, "deleted")
+	histogram.Delete("deleted")
+
+	buf := &strings.Builder{}
+	err := registry.Write(buf)
+	assert.NoError(err)
+
+	expected := `# HELP test_documents Test documents.
+# TYPE test_documents gauge
+test_documents 7
+# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="content",le="0.1"} 1
+test_duration_seconds_bucket{route="content",le="1"} 2
+test_duration_seconds_bucket{route="content",le="+Inf"} 3
+test_duration_seconds_sum{route="content"} 5.55
+test_duration_seconds_count{route="content"} 3
+# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{route="content",status="200"} 3
+test_requests_total{route="content",status="404"} 1
+test_requests_total{route="say \"hi\"\n",status="500"} 1
+`
+	assert.Equal(expected, buf.String())
+	assert.Equal(float64(3), counter.Value("content", "200"))
+}
+
+func TestInstrument(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName     string
+		InputRoute   string
+		InputHandler http.HandlerFunc
+		ExpectStatus string
+		ExpectSize   float64
+	}{
+		{"Instrument implicit 200", "test-implicit", func(w http.ResponseWriter, req *http.Request) {
+			_, _ = w.Write([]byte("hello"))
+		}, "200", 5},
+		{"Instrument explicit status", "test-explicit", func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, "404", 0},
+		{"Instrument empty response", "test-empty", func(w http.ResponseWriter, req *http.Request) {}, "200", 0},
+	}
+
+	for _, test := range tests {
+		before := Requests.Value(test.InputRoute, test.ExpectStatus)
+		w := httptest.NewRecorder()
+		Instrument(test.InputRoute, test.InputHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(before+1, Requests.Value(test.InputRoute, test.ExpectStatus), test.TestName)
+
+		ResponseSize.mutex.Lock()
+		s := ResponseSize.get([]string{test.InputRoute})
+		assert.Equal(test.ExpectSize, s.sum, test.TestName)
+		ResponseSize.mutex.Unlock()
+	}
+}
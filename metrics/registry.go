@@ -0,0 +1,58 @@
+package metrics
+
+// The metrics exposed by this program. Labels are kept to a small, bounded
+// set of values: routes are the handlers registered in main, not request
+// paths, and documents are the ones currently being served, since the
+// series of deleted documents are removed.
+var (
+	Requests = Default.NewCounter(
+		"lightsites_http_requests_total",
+		"Total number of HTTP requests by route and status code.",
+		"route", "status",
+	)
+	RequestDuration = Default.NewHistogram(
+		"lightsites_http_request_duration_seconds",
+		"Time taken to serve HTTP requests by route.",
+		DurationBuckets,
+		"route",
+	)
+	ResponseSize = Default.NewHistogram(
+		"lightsites_http_response_size_bytes",
+		"Size of HTTP response bodies by route.",
+		SizeBuckets,
+		"route",
+	)
+	RefreshDuration = Default.NewHistogram(
+		"lightsites_refresh_duration_seconds",
+		"Time taken to load the site, by kind of refresh (full or incremental).",
+		DurationBuckets,
+		"kind",
+	)
+	RefreshFailures = Default.NewCounter(
+		"lightsites_refresh_failures_total",
+		"Total number of failed attempts to load the site.",
+	)
+	RenderDuration = Default.NewHistogram(
+		"lightsites_document_render_duration_seconds",
+		"Time taken to render each document, by document.",
+		DurationBuckets,
+		"document",
+	)
+	Documents = Default.NewGauge(
+		"lightsites_documents",
+		"Number of documents being served by the current site snapshot.",
+	)
+	FailedDocuments = Default.NewGauge(
+		"lightsites_failed_documents",
+		"Number of documents that failed to render in the current site snapshot.",
+	)
+	Generation = Default.NewGauge(
+		"lightsites_site_generation",
+		"Generation of the current site snapshot.",
+	)
+	TemplateReads = Default.NewCounter(
+		"lightsites_template_reads_total",
+		"Total number of template files read, by template.",
+		"template",
+	)
+)
@@ -0,0 +1,202 @@
+package site
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/document"
+	"lightsites/feed"
+	"lightsites/helpers"
+
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Generated is a file that is generated from all of the site's documents,
+// such as a feed, rather than rendered from a single document
+type Generated struct {
+	// Path is the route of the file, relative to the route prefix
+	Path         string
+	ContentType  string
+	Content      string
+	ETag         string
+	DateModified time.Time
+}
+
+// LookupGenerated returns the generated file routed at path, which is
+// relative to the configured route prefix
+func (s *Site) LookupGenerated(path string) (*Generated, bool) {
+	generated, ok := s.Generated[path]
+	return generated, ok
+}
+
+// GeneratedFiles returns every generated file, sorted by path
+func (s *Site) GeneratedFiles() []*Generated {
+	files := []*Generated{}
+	for _, generated := range s.Generated {
+		files = append(files, generated)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// generatedURL returns the absolute address of a generated file, or of the
+// route prefix if filePath is empty
+func (s *Site) generatedURL(filePath string) string {
+	return fmt.Sprintf("%v%v%v", strings.TrimSuffix(s.Config.BaseURL, "/"), s.Config.Routing.RoutePrefix, filePath)
+}
+
+// newGenerated creates a generated file from its content
+func newGenerated(filePath string, contentType string, content []byte, modified time.Time) *Generated {
+	return &Generated{
+		Path:         filePath,
+		ContentType:  contentType,
+		Content:      string(content),
+		ETag:         helpers.ETag(string(content)),
+		DateModified: modified,
+	}
+}
+
+// documentURL returns the absolute address of a document
+func (s *Site) documentURL(documentName string) string {
+	return fmt.Sprintf("%v%v%v%v", strings.TrimSuffix(s.Config.BaseURL, "/"), s.Config.Routing.RoutePrefix, documentName, s.Config.Routing.UrlFileSuffix)
+}
+
+// BuildFeeds generates every configured feed from the site's documents. A
+// feed that fails to generate is logged and not served.
+func (s *Site) BuildFeeds() {
+	for _, feedConf := range s.Config.Feeds {
+		generated, err := s.buildFeed(feedConf)
+		if err != nil {
+			log.Printf("failed to generate feed %v: %v", feedConf.Path, err.Error())
+			continue
+		}
+		s.Generated[generated.Path] = generated
+	}
+}
+
+// feedDocuments returns the documents a feed lists, newest first. Hidden and
+// draft documents are never listed.
+func (s *Site) feedDocuments(feedConf config.FeedConfig) []*document.Document {
+	directory := strings.Trim(feedConf.Directory, "/")
+	docs := []*document.Document{}
+	for i := range s.Documents {
+		doc := &s.Documents[i]
+		if document.IsHidden(doc.FileName) || doc.Draft {
+			continue
+		}
+		if directory != "" && !strings.HasPrefix(doc.FileName, directory+"/") {
+			continue
+		}
+		if feedConf.Tag != "" && !hasTag(doc.Tags, feedConf.Tag) {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		a, b := publishedDate(docs[i]), publishedDate(docs[j])
+		if a.Equal(b) {
+			return docs[i].FileName < docs[j].FileName
+		}
+		return a.After(b)
+	})
+
+	if feedConf.Limit > 0 && len(docs) > feedConf.Limit {
+		docs = docs[:feedConf.Limit]
+	}
+	return docs
+}
+
+// hasTag returns true if tags contains tag, ignoring case
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// publishedDate returns the date of a document, or when it was last
+// modified if it has none
+func publishedDate(doc *document.Document) time.Time {
+	if doc.Date.IsZero() {
+		return doc.DateModified
+	}
+	return doc.Date
+}
+
+// bodyContent returns the inner HTML of the body of a rendered document
+func bodyContent(contents string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(contents))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse html: %v", err.Error())
+	}
+	body := helpers.GetNodeOfType(doc, constants.BodyNode)
+	if body == nil {
+		return "", nil
+	}
+
+	var buf strings.Builder
+	for c := body.FirstChild; c != nil; c = c.NextSibling {
+		rendered, err := helpers.RenderNode(c)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(rendered)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// buildFeed generates a single feed
+func (s *Site) buildFeed(feedConf config.FeedConfig) (*Generated, error) {
+	feedPath := strings.Trim(feedConf.Path, "/")
+	f := feed.Feed{
+		Title:       feedConf.Title,
+		Description: feedConf.Description,
+		Author:      feedConf.Author,
+		Link:        s.generatedURL(""),
+		FeedURL:     s.generatedURL(feedPath),
+		Items:       []feed.Item{},
+	}
+	if f.Title == "" {
+		f.Title = s.Index.Lookup(constants.IndexDocument).Title
+	}
+
+	for _, doc := range s.feedDocuments(feedConf) {
+		content, err := bodyContent(doc.FileContents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read content of document %v: %v", doc.FileName, err.Error())
+		}
+		summary := doc.Description
+		if summary == "" {
+			summary = s.Index.Lookup(doc.FileName).Summary
+		}
+		f.Items = append(f.Items, feed.Item{
+			Title:     doc.Title,
+			URL:       s.documentURL(doc.DocumentName),
+			Summary:   summary,
+			Content:   content,
+			Published: publishedDate(doc),
+			Updated:   doc.DateModified,
+			Tags:      doc.Tags,
+		})
+		if doc.DateModified.After(f.Updated) {
+			f.Updated = doc.DateModified
+		}
+	}
+	if f.Updated.IsZero() {
+		f.Updated = s.LoadedAt
+	}
+
+	content, contentType, err := feed.Encode(f, feedConf.Format)
+	if err != nil {
+		return nil, err
+	}
+	return newGenerated(feedPath, contentType, content, f.Updated), nil
+}
@@ -0,0 +1,84 @@
+package site
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildFeeds validates that feeds list the public documents of a
+// directory or tag, newest first, with absolute links
+func TestBuildFeeds(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/feed-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Routing.RoutePrefix = "/"
+	conf.BaseURL = "https://example.com/"
+	conf.Feeds = []config.FeedConfig{
+		{Path: "/blog/index.xml", Title: "Blog", Directory: "blog"},
+		{Path: "tags/markdown/feed.json", Format: constants.FeedFormatJSON, Tag: "markdown"},
+		{Path: "feed.atom", Format: constants.FeedFormatAtom, Limit: 2},
+	}
+	require.NoError(conf.Validate())
+
+	s, err := Load(&conf, 1, nil)
+	require.NoError(err)
+
+	var paths []string
+	for _, generated := range s.GeneratedFiles() {
+		paths = append(paths, generated.Path)
+	}
+	assert.Equal([]string{"blog/index.xml", "feed.atom", "tags/markdown/feed.json"}, paths)
+
+	blog, ok := s.LookupGenerated("blog/index.xml")
+	require.True(ok)
+	assert.Equal(constants.RSSContentType, blog.ContentType)
+	assert.NotEmpty(blog.ETag)
+	assert.Contains(blog.Content, `<title>Blog</title>`)
+	assert.Contains(blog.Content, `<link>https://example.com/</link>`)
+	assert.Contains(blog.Content, `<atom:link href="https://example.com/blog/index.xml"`)
+	assert.Contains(blog.Content, `<description>The second post</description>`)
+	assert.Contains(blog.Content, `<description>The first post, about Go.</description>`)
+	assert.Contains(blog.Content, `<![CDATA[<div class="container"><div class="row"><div class="col-lg-12">`)
+	assert.NotContains(blog.Content, "Draft Post")
+	assert.NotContains(blog.Content, "Hidden Post")
+	assert.NotContains(blog.Content, "About")
+	assert.Less(strings.Index(blog.Content, "Second Post"), strings.Index(blog.Content, "First Post"))
+
+	tagged, ok := s.LookupGenerated("tags/markdown/feed.json")
+	require.True(ok)
+	var parsed struct {
+		Title string `json:"title"`
+		Items []struct {
+			URL string `json:"url"`
+		} `json:"items"`
+	}
+	require.NoError(json.Unmarshal([]byte(tagged.Content), &parsed))
+	// without a title, the feed is named after the index document
+	assert.Equal("Feed Site", parsed.Title)
+	require.Len(parsed.Items, 2)
+	assert.Equal("https://example.com/blog/second.html", parsed.Items[0].URL)
+	assert.Equal("https://example.com/about.html", parsed.Items[1].URL)
+
+	all, ok := s.LookupGenerated("feed.atom")
+	require.True(ok)
+	assert.Equal(constants.AtomContentType, all.ContentType)
+	assert.Contains(all.Content, "Second Post")
+	assert.Contains(all.Content, "First Post")
+	assert.NotContains(all.Content, "<title>About</title>")
+
+	// feeds are carried through incremental renders
+	rerendered, err := s.Rerender(&conf, 2, []string{"blog/first"}, false)
+	require.NoError(err)
+	_, ok = rerendered.LookupGenerated("blog/index.xml")
+	assert.True(ok)
+}
@@ -0,0 +1,703 @@
+package site
+
+import (
+	"lightsites/compression"
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/document"
+	"lightsites/helpers"
+	"lightsites/metrics"
+	"lightsites/watcher"
+
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Site is an immutable snapshot of every rendered document, along with the
+// directory listing and configuration that were used to render them. Once a
+// Site has been published to a Store it must not be modified, so that
+// handlers can read from it without any locking.
+type Site struct {
+	Documents        []document.Document
+	DirectoryListing helpers.DirectoryListing
+	// Index holds the metadata of every document in the directory listing,
+	// which is read before any document is rendered
+	Index      document.Index
+	Config     *config.Config
+	Generation uint64
+	LoadedAt   time.Time
+	// Routes maps a request path, relative to the configured route prefix,
+	// to the document that should be served for it
+	Routes map[string]*document.Document
+	// Errors holds the error for each document file that failed to parse,
+	// keyed by the file name relative to the documents directory
+	Errors map[string]error
+	// Failed holds the partially processed documents that failed to render.
+	// They are never served, but their recorded dependencies determine when
+	// they should be rendered again.
+	Failed []document.Document
+	// ErrorPage is served for documents that failed to render and have no
+	// previous render to fall back on
+	ErrorPage string
+	// Generated holds the files generated from all of the documents, such
+	// as feeds and the sitemap, keyed by their route relative to the route
+	// prefix
+	Generated map[string]*Generated
+	// Robots is robots.txt, which is served at the root rather than under
+	// the route prefix, or nil if it is disabled
+	Robots *Generated
+}
+
+// Failure describes a document that failed to render
+type Failure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+	// Stale is set when the previous successful render of the document is
+	// being served instead
+	Stale bool `json:"stale"`
+}
+
+// DocumentViolations lists the parts of a document that break the
+// no-JavaScript policy
+type DocumentViolations struct {
+	File       string               `json:"file"`
+	Violations []document.Violation `json:"violations"`
+}
+
+// Report summarizes a site snapshot, including every document that failed
+// to render
+type Report struct {
+	Generation uint64               `json:"generation"`
+	LoadedAt   time.Time            `json:"loadedAt"`
+	Documents  int                  `json:"documents"`
+	Failures   []Failure            `json:"failures"`
+	Violations []DocumentViolations `json:"violations"`
+}
+
+// newSite creates an empty Site for the configured documents directory
+func newSite(conf *config.Config, generation uint64) *Site {
+	return &Site{
+		DirectoryListing: helpers.DirectoryListing{
+			Path:  conf.Directories.Documents,
+			Files: []string{},
+		},
+		Index:      document.Index{},
+		Config:     conf,
+		Generation: generation,
+		Documents:  []document.Document{},
+		Routes:     make(map[string]*document.Document),
+		Errors:     make(map[string]error),
+		Failed:     []document.Document{},
+		Generated:  make(map[string]*Generated),
+	}
+}
+
+// Load walks the configured documents directory and parses every document
+// into a new Site. An error is only returned if the directory itself can't
+// be read; errors for individual documents are recorded in Site.Errors.
+//
+// If previous is provided and serving stale documents is enabled, a
+// document that fails to render is replaced by its render from previous.
+func Load(conf *config.Config, generation uint64, previous *Site) (*Site, error) {
+	s := newSite(conf, generation)
+
+	err := s.DirectoryListing.WalkDirectory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %v: %w", conf.Directories.Documents, err)
+	}
+
+	s.render(previous, nil)
+
+	return s, nil
+}
+
+// render parses every file in the site's directory listing. When rerender is
+// nil every file is parsed, otherwise only the files in rerender are parsed
+// and everything else is carried over from previous. A document that fails
+// to render falls back to its last successful render in previous, if
+// serving stale documents is enabled.
+func (s *Site) render(previous *Site, rerender map[string]bool) {
+	previousDocuments := make(map[string]*document.Document)
+	previousFailed := make(map[string]*document.Document)
+	if previous != nil {
+		for i := range previous.Documents {
+			previousDocuments[previous.Documents[i].FileName] = &previous.Documents[i]
+		}
+		for i := range previous.Failed {
+			previousFailed[previous.Failed[i].FileName] = &previous.Failed[i]
+		}
+	}
+
+	rerender = s.buildIndex(previous, rerender)
+
+	for _, file := range s.DirectoryListing.Files {
+		if rerender != nil && !rerender[file] {
+			doc, rendered := previousDocuments[file]
+			if rendered {
+				carried := *doc
+				carried.DocumentDirectory = &s.DirectoryListing.Files
+				carried.Index = s.Index
+				s.Documents = append(s.Documents, carried)
+			}
+			failed, isFailed := previousFailed[file]
+			if isFailed {
+				carried := *failed
+				carried.DocumentDirectory = &s.DirectoryListing.Files
+				carried.Index = s.Index
+				s.Failed = append(s.Failed, carried)
+				s.Errors[file] = previous.Errors[file]
+			}
+			if rendered || isFailed {
+				continue
+			}
+		}
+
+		start := time.Now()
+		doc, err := document.NewDocument(s.Config, &s.DirectoryListing.Files, s.Index, file)
+		metrics.RenderDuration.ObserveDuration(start, file)
+		if err != nil {
+			s.Errors[file] = err
+			s.Failed = append(s.Failed, doc)
+
+			last, ok := previousDocuments[file]
+			if ok && s.Config.FailedDocuments.ServeStale {
+				stale := *last
+				stale.Stale = true
+				stale.DocumentDirectory = &s.DirectoryListing.Files
+				stale.Index = s.Index
+				s.Documents = append(s.Documents, stale)
+			}
+			continue
+		}
+
+		// documents are compressed once per render rather than on every
+		// request
+		encoded, err := compression.EncodeAll(s.Config, []byte(doc.FileContents))
+		if err != nil {
+			log.Printf("failed to compress document %v, serving it uncompressed: %v", file, err.Error())
+		}
+		doc.Encodings = make(map[string]string)
+		for encoding, content := range encoded {
+			doc.Encodings[encoding] = string(content)
+		}
+
+		last, ok := previousDocuments[file]
+		if ok {
+			doc.DateModified = dateModified(doc.ETag, doc.DateModified, last.ETag, last.DateModified)
+		}
+
+		s.Documents = append(s.Documents, doc)
+	}
+
+	s.BuildRoutes()
+	s.LoadErrorPage()
+	s.LoadedAt = time.Now()
+	s.BuildFeeds()
+	s.BuildSitemap()
+	s.BuildRobots()
+
+	if previous != nil {
+		for filePath, generated := range s.Generated {
+			last, ok := previous.Generated[filePath]
+			if ok {
+				generated.DateModified = dateModified(generated.ETag, generated.DateModified, last.ETag, last.DateModified)
+			}
+		}
+		if s.Robots != nil && previous.Robots != nil {
+			s.Robots.DateModified = dateModified(s.Robots.ETag, s.Robots.DateModified, previous.Robots.ETag, previous.Robots.DateModified)
+		}
+	}
+}
+
+// dateModified returns the modification time of content that was rendered
+// again, given the modification time of the files it was rendered from and
+// its previous render. Content that didn't change keeps its previous
+// modification time. Content that did change, such as a listing of other
+// documents, may not have had any of its own files modified, so it is
+// modified at least as late as now. Since Last-Modified has a resolution of
+// a second, changed content is always modified in a later second than
+// before, so that If-Modified-Since never matches it.
+func dateModified(etag string, modified time.Time, previousETag string, previousModified time.Time) time.Time {
+	if etag == previousETag {
+		return previousModified
+	}
+	previousSecond := previousModified.Truncate(time.Second)
+	for _, candidate := range []time.Time{modified, time.Now()} {
+		if candidate.Truncate(time.Second).After(previousSecond) {
+			return candidate
+		}
+	}
+	return previousSecond.Add(time.Second)
+}
+
+// buildIndex reads the metadata of every file in the site's directory
+// listing into the index. When rerender is nil every file is read,
+// otherwise the entries of the files that aren't rendered again are carried
+// over from previous. Since documents that depend on the index, such as
+// those with a <directory> tag, list the titles and dates of other
+// documents, they are added to the returned rerender set whenever the
+// metadata of a document changed.
+func (s *Site) buildIndex(previous *Site, rerender map[string]bool) map[string]bool {
+	changed := false
+	for _, file := range s.DirectoryListing.Files {
+		if rerender != nil && !rerender[file] {
+			entry, ok := previous.Index[file]
+			if ok {
+				s.Index[file] = entry
+				continue
+			}
+		}
+
+		entry, err := document.ReadIndexEntry(s.Config, file)
+		if err != nil {
+			// the error is reported once the document is rendered
+			entry = document.IndexEntry{FileName: file}
+		}
+		s.Index[file] = entry
+
+		if previous != nil {
+			previousEntry, ok := previous.Index[file]
+			if ok && !reflect.DeepEqual(previousEntry, entry) {
+				changed = true
+			}
+		}
+	}
+
+	if rerender == nil || !changed {
+		return rerender
+	}
+
+	result := make(map[string]bool)
+	for file := range rerender {
+		result[file] = true
+	}
+	for _, docs := range [][]document.Document{previous.Documents, previous.Failed} {
+		for i := range docs {
+			if docs[i].DependsOnIndex() {
+				result[docs[i].FileName] = true
+			}
+		}
+	}
+	return result
+}
+
+// LoadErrorPage reads the configured error page, if there is one, so that
+// it doesn't have to be read for every request
+func (s *Site) LoadErrorPage() {
+	if s.Config.FailedDocuments.ErrorPage == "" {
+		return
+	}
+	content, err := ioutil.ReadFile(s.Config.FailedDocuments.ErrorPage)
+	if err != nil {
+		log.Printf("failed to read error page %v: %v", s.Config.FailedDocuments.ErrorPage, err.Error())
+		return
+	}
+	s.ErrorPage = string(content)
+}
+
+// BuildRoutes populates the route table from the site's documents. Every
+// document is routed at `<DocumentName><urlFileSuffix>`, and additionally at
+// each of its aliases and at its slug, which replaces the last element of
+// the document name. The index document is also routed at the bare route
+// prefix. Document names always take precedence over aliases and slugs, and
+// the first alias registered for a path wins.
+func (s *Site) BuildRoutes() {
+	suffix := s.Config.Routing.UrlFileSuffix
+
+	for i := range s.Documents {
+		doc := &s.Documents[i]
+		s.Routes[fmt.Sprintf("%v%v", doc.DocumentName, suffix)] = doc
+	}
+
+	index, ok := s.Routes[fmt.Sprintf("%v%v", constants.IndexDocument, suffix)]
+	if ok {
+		s.Routes[""] = index
+	}
+
+	for i := range s.Documents {
+		doc := &s.Documents[i]
+		aliases := append([]string{}, doc.Aliases...)
+		if doc.Slug != "" {
+			aliases = append(aliases, path.Join(path.Dir(doc.DocumentName), doc.Slug))
+		}
+		for _, alias := range aliases {
+			alias = strings.Trim(strings.TrimSpace(alias), "/")
+			if alias == "" {
+				continue
+			}
+			route := fmt.Sprintf("%v%v", alias, suffix)
+			existing, ok := s.Routes[route]
+			if ok {
+				if existing != doc {
+					log.Printf("ignoring alias %v for document %v: route is already used by %v", alias, doc.DocumentName, existing.DocumentName)
+				}
+				continue
+			}
+			s.Routes[route] = doc
+		}
+	}
+}
+
+// Lookup returns the document routed at path, which is relative to the
+// configured route prefix
+func (s *Site) Lookup(path string) (*document.Document, bool) {
+	doc, ok := s.Routes[path]
+	return doc, ok
+}
+
+// LookupFailure returns the name of the document file that failed to render
+// for path, which is relative to the configured route prefix. Documents that
+// are being served stale are found by Lookup instead.
+func (s *Site) LookupFailure(path string) (file string, ok bool) {
+	suffix := s.Config.Routing.UrlFileSuffix
+	if path == "" {
+		return constants.IndexDocument, s.Errors[constants.IndexDocument] != nil
+	}
+	if !strings.HasSuffix(path, suffix) {
+		return "", false
+	}
+	file = strings.TrimSuffix(path, suffix)
+	_, ok = s.Errors[file]
+	return file, ok
+}
+
+// ErrorFiles returns the names of all documents that failed to parse,
+// sorted alphabetically
+func (s *Site) ErrorFiles() []string {
+	files := []string{}
+	for file := range s.Errors {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Failures returns every document that failed to render, sorted by file
+func (s *Site) Failures() []Failure {
+	stale := make(map[string]bool)
+	for i := range s.Documents {
+		if s.Documents[i].Stale {
+			stale[s.Documents[i].FileName] = true
+		}
+	}
+
+	failures := []Failure{}
+	for _, file := range s.ErrorFiles() {
+		failures = append(failures, Failure{
+			File:  file,
+			Error: s.Errors[file].Error(),
+			Stale: stale[file],
+		})
+	}
+	return failures
+}
+
+// Violations returns the no-JavaScript policy violations of every document,
+// including documents that failed to render, sorted by file. For a document
+// that failed to render, the violations of the failed render are reported
+// rather than those of the stale render being served.
+func (s *Site) Violations() []DocumentViolations {
+	byFile := make(map[string][]document.Violation)
+	for _, docs := range [][]document.Document{s.Failed, s.Documents} {
+		for i := range docs {
+			_, ok := byFile[docs[i].FileName]
+			if ok || len(docs[i].Violations) == 0 {
+				continue
+			}
+			byFile[docs[i].FileName] = docs[i].Violations
+		}
+	}
+
+	files := []string{}
+	for file := range byFile {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	violations := []DocumentViolations{}
+	for _, file := range files {
+		violations = append(violations, DocumentViolations{File: file, Violations: byFile[file]})
+	}
+	return violations
+}
+
+// LogViolations logs every part of a document that breaks the no-JavaScript
+// policy
+func (s *Site) LogViolations() {
+	for _, document := range s.Violations() {
+		for _, violation := range document.Violations {
+			log.Printf("document %v breaks the no-JavaScript policy: %v", document.File, violation)
+		}
+	}
+}
+
+// Report summarizes the site, for the status endpoint and command line
+func (s *Site) Report() Report {
+	return Report{
+		Generation: s.Generation,
+		LoadedAt:   s.LoadedAt,
+		Documents:  len(s.Documents),
+		Failures:   s.Failures(),
+		Violations: s.Violations(),
+	}
+}
+
+// Health describes whether the most recent attempts to load the site
+// succeeded. The state is loading until the site has been loaded for the
+// first time. When loading fails, the previous snapshot keeps being served
+// and the state becomes degraded until a load succeeds again.
+type Health struct {
+	State string `json:"state"`
+	// LastSuccess is when the site was last loaded successfully
+	LastSuccess time.Time `json:"lastSuccess"`
+	// LastError is the most recent load error, if the most recent load failed
+	LastError   string    `json:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt"`
+	// FailedPath is the path that couldn't be read, if known
+	FailedPath          string `json:"failedPath,omitempty"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+}
+
+// Store holds the currently published Site. Readers call Load to get a
+// consistent snapshot, while a refresh publishes a complete replacement
+// with a single atomic swap.
+type Store struct {
+	value atomic.Value
+	// mutex serializes refreshes so that generations are published in order
+	mutex      sync.Mutex
+	generation uint64
+	// health is guarded by its own mutex, so that it can be read while a
+	// refresh is in progress
+	healthMutex sync.RWMutex
+	health      Health
+}
+
+// Load returns the currently published Site, or nil if nothing has been
+// published yet
+func (store *Store) Load() *Site {
+	s, _ := store.value.Load().(*Site)
+	return s
+}
+
+// Health returns the outcome of the most recent attempts to load the site
+func (store *Store) Health() Health {
+	store.healthMutex.RLock()
+	defer store.healthMutex.RUnlock()
+	health := store.health
+	if health.State == "" {
+		health.State = constants.HealthStateLoading
+	}
+	return health
+}
+
+// recordSuccess marks the store as healthy after a successful load
+func (store *Store) recordSuccess() {
+	store.healthMutex.Lock()
+	defer store.healthMutex.Unlock()
+	store.health = Health{
+		State:       constants.HealthStateOK,
+		LastSuccess: time.Now(),
+	}
+}
+
+// recordFailure marks the store as degraded after a failed load
+func (store *Store) recordFailure(err error) {
+	store.healthMutex.Lock()
+	defer store.healthMutex.Unlock()
+	store.health.State = constants.HealthStateDegraded
+	store.health.LastError = err.Error()
+	store.health.LastErrorAt = time.Now()
+	store.health.FailedPath = helpers.FailedPath(err)
+	store.health.ConsecutiveFailures++
+	metrics.RefreshFailures.Inc()
+}
+
+// Refresh loads a new Site from the configured documents directory and
+// publishes it, replacing the previous snapshot. If loading fails, the
+// previous snapshot remains published and the store's health is degraded.
+func (store *Store) Refresh(conf *config.Config) (*Site, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	start := time.Now()
+	s, err := Load(conf, store.generation+1, store.Load())
+	metrics.RefreshDuration.ObserveDuration(start, constants.RefreshKindFull)
+	if err != nil {
+		store.recordFailure(err)
+		return nil, err
+	}
+
+	store.publish(s)
+
+	return s, nil
+}
+
+// Apply publishes a new Site that reflects a set of changes reported by the
+// watcher. Only the documents affected by the changes are rendered again,
+// and everything else is carried over from the current snapshot. The current
+// snapshot is returned unchanged if none of the changes affect any documents.
+func (store *Store) Apply(conf *config.Config, changes []watcher.Change) (*Site, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.Load()
+	start := time.Now()
+	if current == nil {
+		s, err := Load(conf, store.generation+1, nil)
+		metrics.RefreshDuration.ObserveDuration(start, constants.RefreshKindFull)
+		if err != nil {
+			store.recordFailure(err)
+			return nil, err
+		}
+		store.publish(s)
+		return s, nil
+	}
+
+	files, listingChanged := current.AffectedDocuments(conf, changes)
+	if len(files) == 0 && !listingChanged {
+		return current, nil
+	}
+
+	s, err := current.Rerender(conf, store.generation+1, files, listingChanged)
+	metrics.RefreshDuration.ObserveDuration(start, constants.RefreshKindIncremental)
+	if err != nil {
+		store.recordFailure(err)
+		return nil, err
+	}
+
+	store.publish(s)
+
+	return s, nil
+}
+
+// publish makes s the current snapshot. The caller must hold the mutex.
+func (store *Store) publish(s *Site) {
+	previous := store.Load()
+	store.generation = s.Generation
+	store.value.Store(s)
+	store.recordSuccess()
+
+	// documents that no longer exist stop being reported
+	if previous != nil {
+		files := make(map[string]bool)
+		for _, file := range s.DirectoryListing.Files {
+			files[file] = true
+		}
+		for _, file := range previous.DirectoryListing.Files {
+			if !files[file] {
+				metrics.RenderDuration.Delete(file)
+			}
+		}
+	}
+
+	metrics.Generation.Set(float64(s.Generation))
+	metrics.Documents.Set(float64(len(s.Documents)))
+	metrics.FailedDocuments.Set(float64(len(s.Failed)))
+}
+
+// Dependents returns the names of all documents that use the template file,
+// including documents that failed to render, sorted alphabetically
+func (s *Site) Dependents(templateFile string) []string {
+	dependents := make(map[string]bool)
+	for _, docs := range [][]document.Document{s.Documents, s.Failed} {
+		for i := range docs {
+			if docs[i].DependsOnTemplate(templateFile) {
+				dependents[docs[i].FileName] = true
+			}
+		}
+	}
+
+	files := []string{}
+	for file := range dependents {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// AffectedDocuments maps changes reported by the watcher to the names of the
+// documents that have to be rendered again, using the dependencies recorded
+// for each document:
+//
+// * a modified or created document is rendered again
+// * a changed template causes every document that uses it to be rendered again
+// * a created or deleted document changes the list of documents, so every
+// document that depends on the index, such as one containing a <directory>
+// tag, is rendered again
+//
+// listingChanged is true when documents were created or deleted, meaning
+// that the documents directory has to be walked again.
+func (s *Site) AffectedDocuments(conf *config.Config, changes []watcher.Change) (files []string, listingChanged bool) {
+	affected := make(map[string]bool)
+	for _, change := range changes {
+		switch change.Directory {
+		case conf.Directories.Templates:
+			for _, file := range s.Dependents(change.File) {
+				affected[file] = true
+			}
+		case conf.Directories.Documents:
+			if !strings.HasSuffix(change.File, constants.MarkdownFileSuffix) {
+				continue
+			}
+			if change.Op != watcher.Modified {
+				listingChanged = true
+			}
+			if change.Op != watcher.Deleted {
+				affected[strings.TrimSuffix(change.File, constants.MarkdownFileSuffix)] = true
+			}
+		}
+	}
+
+	if listingChanged {
+		for _, docs := range [][]document.Document{s.Documents, s.Failed} {
+			for i := range docs {
+				if docs[i].DependsOnIndex() {
+					affected[docs[i].FileName] = true
+				}
+			}
+		}
+	}
+
+	files = []string{}
+	for file := range affected {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	return files, listingChanged
+}
+
+// Rerender returns a copy of the site with the given generation in which
+// only the named documents are parsed again. All other documents and their
+// errors are carried over as they are. If walk is set, the documents
+// directory is walked again first, so that created documents are parsed
+// and deleted documents are dropped.
+func (s *Site) Rerender(conf *config.Config, generation uint64, files []string, walk bool) (*Site, error) {
+	n := newSite(conf, generation)
+	n.DirectoryListing.Files = append(n.DirectoryListing.Files, s.DirectoryListing.Files...)
+
+	if walk {
+		n.DirectoryListing.Files = []string{}
+		err := n.DirectoryListing.WalkDirectory()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory %v: %w", conf.Directories.Documents, err)
+		}
+	}
+
+	rerender := make(map[string]bool)
+	for _, file := range files {
+		rerender[file] = true
+	}
+
+	n.render(s, rerender)
+
+	return n, nil
+}
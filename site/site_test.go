@@ -0,0 +1,480 @@
+package site
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+	"lightsites/document"
+	"lightsites/helpers"
+	"lightsites/watcher"
+
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+
+	tests := []struct {
+		TestName        string
+		InputDirectory  string
+		ExpectDocuments int
+		ExpectErrors    []string
+		ExpectError     bool
+	}{
+		{"Load happy path", "../tests/export-docs", 2, []string{}, false},
+		{"Load documents without titles", "../tests/walkstep", 0, []string{"nested1/nestedtest1", "nested2/nestedtest2", "test1"}, false},
+		{"Load non-existent directory", "../tests/does-not-exist", 0, nil, true},
+	}
+
+	for _, test := range tests {
+		testConf := conf
+		testConf.Directories.Documents = test.InputDirectory
+
+		s, err := Load(&testConf, 7, nil)
+		if test.ExpectError {
+			assert.Error(err, test.TestName)
+			assert.Nil(s, test.TestName)
+			continue
+		}
+
+		require.NoError(err, test.TestName)
+		assert.Equal(uint64(7), s.Generation, test.TestName)
+		assert.Len(s.Documents, test.ExpectDocuments, test.TestName)
+		assert.Equal(test.ExpectErrors, s.ErrorFiles(), test.TestName)
+		assert.False(s.LoadedAt.IsZero(), test.TestName)
+	}
+}
+
+// TestStoreRefresh validates that generations increase with every
+// successful refresh, that a failed refresh leaves the previous snapshot
+// published, and that concurrent readers always see a complete snapshot
+func TestStoreRefresh(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	store := Store{}
+	assert.Nil(store.Load())
+
+	s, err := store.Refresh(&conf)
+	require.NoError(err)
+	assert.Equal(uint64(1), s.Generation)
+	assert.Equal(s, store.Load())
+
+	assert.Equal(constants.HealthStateOK, store.Health().State)
+
+	badConf := conf
+	badConf.Directories.Documents = "../tests/does-not-exist"
+	_, err = store.Refresh(&badConf)
+	assert.Error(err)
+	assert.Equal(s, store.Load())
+	_, err = store.Refresh(&badConf)
+	assert.Error(err)
+
+	health := store.Health()
+	assert.Equal(constants.HealthStateDegraded, health.State)
+	assert.Equal(2, health.ConsecutiveFailures)
+	assert.Equal("../tests/does-not-exist", health.FailedPath)
+	assert.Contains(health.LastError, "does-not-exist")
+	assert.False(health.LastSuccess.IsZero())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := store.Refresh(&conf)
+			assert.NoError(err)
+		}()
+		go func() {
+			defer wg.Done()
+			current := store.Load()
+			assert.Len(current.Documents, len(current.DirectoryListing.Files))
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(uint64(5), store.Load().Generation)
+	assert.Equal(constants.HealthStateOK, store.Health().State)
+	assert.Equal(0, store.Health().ConsecutiveFailures)
+}
+
+func TestBuildRoutes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+
+	s, err := Load(&conf, 1, nil)
+	require.NoError(err)
+
+	tests := []struct {
+		TestName     string
+		InputPath    string
+		ExpectFound  bool
+		ExpectTarget string
+	}{
+		{"Lookup document", "index.html", true, "index"},
+		{"Lookup nested document", "blog/post.html", true, "blog/post"},
+		{"Lookup bare route prefix", "", true, "index"},
+		{"Lookup alias", "old-post.html", true, "blog/post"},
+		{"Lookup nested alias", "blog/older-post.html", true, "blog/post"},
+		{"Lookup without suffix", "index", false, ""},
+		{"Lookup missing document", "missing.html", false, ""},
+	}
+
+	for _, test := range tests {
+		doc, ok := s.Lookup(test.InputPath)
+		assert.Equal(test.ExpectFound, ok, test.TestName)
+		if test.ExpectFound {
+			assert.Equal(test.ExpectTarget, doc.DocumentName, test.TestName)
+		}
+	}
+}
+
+// TestBuildRoutesAliasCollision validates that an alias never replaces a
+// route that belongs to a real document, and that slugs are routed
+// relative to the document's directory
+func TestBuildRoutesAliasCollision(t *testing.T) {
+	assert := assert.New(t)
+
+	conf := config.GetDefaultConfig()
+	s := &Site{
+		Config: &conf,
+		Routes: make(map[string]*document.Document),
+		Documents: []document.Document{
+			{DocumentName: "a", Aliases: []string{"b", "c"}},
+			{DocumentName: "b"},
+			{DocumentName: "blog/d", Slug: "hello", Aliases: []string{"c"}},
+		},
+	}
+	s.BuildRoutes()
+
+	tests := []struct {
+		TestName     string
+		InputPath    string
+		ExpectFound  bool
+		ExpectTarget string
+	}{
+		{"Lookup document shadowing an alias", "b.html", true, "b"},
+		{"Lookup alias claimed first", "c.html", true, "a"},
+		{"Lookup slug", "blog/hello.html", true, "blog/d"},
+		{"Lookup without index", "", false, ""},
+	}
+
+	for _, test := range tests {
+		doc, ok := s.Lookup(test.InputPath)
+		assert.Equal(test.ExpectFound, ok, test.TestName)
+		if test.ExpectFound {
+			assert.Equal(test.ExpectTarget, doc.DocumentName, test.TestName)
+		}
+	}
+}
+
+func TestAffectedDocuments(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = "../tests/export-docs"
+	docs := conf.Directories.Documents
+	templates := conf.Directories.Templates
+
+	s, err := Load(&conf, 1, nil)
+	require.NoError(err)
+
+	tests := []struct {
+		TestName      string
+		InputChange   []watcher.Change
+		ExpectFiles   []string
+		ExpectListing bool
+	}{
+		{
+			"AffectedDocuments modified documents",
+			[]watcher.Change{
+				{Directory: docs, File: "index.md", Op: watcher.Modified},
+				{Directory: docs, File: "blog/post.md", Op: watcher.Modified},
+			},
+			[]string{"blog/post", "index"},
+			false,
+		},
+		{
+			"AffectedDocuments ignores non-markdown files",
+			[]watcher.Change{{Directory: docs, File: "notes.txt", Op: watcher.Created}},
+			[]string{},
+			false,
+		},
+		{
+			"AffectedDocuments created document re-renders directory listings",
+			[]watcher.Change{{Directory: docs, File: "new.md", Op: watcher.Created}},
+			[]string{"index", "new"},
+			true,
+		},
+		{
+			"AffectedDocuments deleted document re-renders directory listings",
+			[]watcher.Change{{Directory: docs, File: "blog/post.md", Op: watcher.Deleted}},
+			[]string{"index"},
+			true,
+		},
+		{
+			"AffectedDocuments modified template re-renders its dependents",
+			[]watcher.Change{{Directory: templates, File: "alert.html", Op: watcher.Modified}},
+			[]string{"blog/post"},
+			false,
+		},
+		{
+			"AffectedDocuments unused template",
+			[]watcher.Change{{Directory: templates, File: "invalid.html", Op: watcher.Modified}},
+			[]string{},
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		files, listingChanged := s.AffectedDocuments(&conf, test.InputChange)
+		assert.Equal(test.ExpectFiles, files, test.TestName)
+		assert.Equal(test.ExpectListing, listingChanged, test.TestName)
+	}
+
+	assert.Equal([]string{"blog/post"}, s.Dependents("alert.html"))
+	assert.Equal([]string{"blog/post"}, s.Dependents("./alert.html"))
+	assert.Equal([]string{}, s.Dependents("invalid.html"))
+}
+
+// TestStoreApply validates that modifying a document only re-renders that
+// document, and that creating a document reloads the whole site
+func TestStoreApply(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(helpers.CopyDirectory("../tests/export-docs", dir))
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = dir
+
+	store := Store{}
+	before, err := store.Refresh(&conf)
+	require.NoError(err)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "index.md"), []byte(`<attributes title="New Home"></attributes><directory></directory>`), 0644))
+	after, err := store.Apply(&conf, []watcher.Change{{Directory: dir, File: "index.md", Op: watcher.Modified}})
+	require.NoError(err)
+
+	assert.Equal(uint64(2), after.Generation)
+	index, ok := after.Lookup("index.html")
+	require.True(ok)
+	assert.Contains(index.FileContents, "<title>New Home</title>")
+	post, ok := after.Lookup("blog/post.html")
+	require.True(ok)
+	previousPost, _ := before.Lookup("blog/post.html")
+	assert.Equal(previousPost.FileContents, post.FileContents)
+	assert.Equal(&after.DirectoryListing.Files, post.DocumentDirectory)
+
+	// the old snapshot must be left untouched
+	previousIndex, _ := before.Lookup("index.html")
+	assert.Contains(previousIndex.FileContents, "<title>Home</title>")
+
+	// changes that don't affect documents don't publish a new generation
+	unchanged, err := store.Apply(&conf, []watcher.Change{{Directory: dir, File: "notes.txt", Op: watcher.Created}})
+	require.NoError(err)
+	assert.Equal(after, unchanged)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "new.md"), []byte(`<attributes title="New"></attributes>`), 0644))
+	reloaded, err := store.Apply(&conf, []watcher.Change{{Directory: dir, File: "new.md", Op: watcher.Created}})
+	require.NoError(err)
+	assert.Equal(uint64(3), reloaded.Generation)
+	_, ok = reloaded.Lookup("new.html")
+	assert.True(ok)
+	index, _ = reloaded.Lookup("index.html")
+	assert.Contains(index.FileContents, `href="/content/new.html"`)
+
+	require.NoError(os.Remove(filepath.Join(dir, "new.md")))
+	reloaded, err = store.Apply(&conf, []watcher.Change{{Directory: dir, File: "new.md", Op: watcher.Deleted}})
+	require.NoError(err)
+	_, ok = reloaded.Lookup("new.html")
+	assert.False(ok)
+	index, _ = reloaded.Lookup("index.html")
+	assert.NotContains(index.FileContents, `href="/content/new.html"`)
+}
+
+// TestStoreApplyMetadata validates that changing the title of a document
+// re-renders the documents that list it with a <directory> tag
+func TestStoreApplyMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(helpers.CopyDirectory("../tests/export-docs", dir))
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = dir
+
+	store := Store{}
+	before, err := store.Refresh(&conf)
+	require.NoError(err)
+	assert.Equal("Post", before.Index["blog/post"].Title)
+	index, ok := before.Lookup("index.html")
+	require.True(ok)
+	assert.Contains(index.FileContents, `rel="noopener noreferrer">Post</a>`)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "blog", "post.md"), []byte(`<attributes title="Renamed Post"></attributes>`), 0644))
+	after, err := store.Apply(&conf, []watcher.Change{{Directory: dir, File: "blog/post.md", Op: watcher.Modified}})
+	require.NoError(err)
+
+	assert.Equal("Renamed Post", after.Index["blog/post"].Title)
+	index, ok = after.Lookup("index.html")
+	require.True(ok)
+	assert.Contains(index.FileContents, `rel="noopener noreferrer">Renamed Post</a>`)
+}
+
+// TestStoreApplyIndex validates that documents that read other documents'
+// metadata through templates are rendered again when that metadata changes
+func TestStoreApplyIndex(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(helpers.CopyDirectory("../tests/index-docs", dir))
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = "../tests/templates"
+	conf.Directories.Documents = dir
+	conf.Routing.RoutePrefix = "/"
+
+	store := Store{}
+	before, err := store.Refresh(&conf)
+	require.NoError(err)
+	index, ok := before.Lookup("index.html")
+	require.True(ok)
+	assert.Contains(index.FileContents, `<li><a href="/guide.html">Guide</a></li>`)
+
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "guide.md"), []byte("<attributes title=\"Handbook\"></attributes>\n\nGo back [home](index.md).\n"), 0644))
+	after, err := store.Apply(&conf, []watcher.Change{{Directory: dir, File: "guide.md", Op: watcher.Modified}})
+	require.NoError(err)
+
+	index, ok = after.Lookup("index.html")
+	require.True(ok)
+	assert.Contains(index.FileContents, `<li><a href="/guide.html">Handbook</a></li>`)
+}
+
+// TestFailedDocuments validates that a document that fails to render keeps
+// serving its previous render when enabled, is reported, and is rendered
+// again once the template it is missing is created
+func TestFailedDocuments(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	templatesDir := t.TempDir()
+	require.NoError(helpers.CopyDirectory("../tests/export-docs", dir))
+	require.NoError(helpers.CopyDirectory("../tests/templates", templatesDir))
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Templates = templatesDir
+	conf.Directories.Documents = dir
+
+	store := Store{}
+	_, err := store.Refresh(&conf)
+	require.NoError(err)
+
+	// break the post by removing the template it uses
+	require.NoError(os.Remove(filepath.Join(templatesDir, "alert.html")))
+	s, err := store.Apply(&conf, []watcher.Change{{Directory: templatesDir, File: "alert.html", Op: watcher.Deleted}})
+	require.NoError(err)
+
+	post, ok := s.Lookup("blog/post.html")
+	require.True(ok)
+	assert.True(post.Stale)
+	assert.Contains(post.FileContents, "alert alert-primary")
+	require.Len(s.Failures(), 1)
+	assert.Equal("blog/post", s.Failures()[0].File)
+	assert.True(s.Failures()[0].Stale)
+	assert.Equal([]string{"blog/post"}, s.Dependents("alert.html"))
+	report := s.Report()
+	assert.Equal(s.Generation, report.Generation)
+	assert.Equal(2, report.Documents)
+
+	// the failure and the stale document are carried over by unrelated changes
+	s, err = store.Apply(&conf, []watcher.Change{{Directory: dir, File: "index.md", Op: watcher.Modified}})
+	require.NoError(err)
+	post, ok = s.Lookup("blog/post.html")
+	require.True(ok)
+	assert.True(post.Stale)
+	assert.Len(s.Failures(), 1)
+
+	// without serving stale documents, the failure is all that remains
+	conf.FailedDocuments.ServeStale = false
+	s, err = store.Refresh(&conf)
+	require.NoError(err)
+	_, ok = s.Lookup("blog/post.html")
+	assert.False(ok)
+	file, failed := s.LookupFailure("blog/post.html")
+	assert.True(failed)
+	assert.Equal("blog/post", file)
+	_, failed = s.LookupFailure("index.html")
+	assert.False(failed)
+	assert.False(s.Failures()[0].Stale)
+
+	// creating the template again fixes the post
+	require.NoError(helpers.CopyFile("../tests/templates/alert.html", filepath.Join(templatesDir, "alert.html")))
+	s, err = store.Apply(&conf, []watcher.Change{{Directory: templatesDir, File: "alert.html", Op: watcher.Created}})
+	require.NoError(err)
+	post, ok = s.Lookup("blog/post.html")
+	require.True(ok)
+	assert.False(post.Stale)
+	assert.Len(s.Failures(), 0)
+}
+
+// TestViolations validates that no-JavaScript policy violations are reported
+// per document, including for documents that failed to render because of
+// them
+func TestViolations(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	tests := []struct {
+		TestName        string
+		InputMode       string
+		ExpectDocuments int
+		ExpectFailures  int
+	}{
+		{"Violations stripped", constants.SanitizeModeStrip, 1, 0},
+		{"Violations failing the document", constants.SanitizeModeFail, 0, 1},
+	}
+
+	for _, test := range tests {
+		conf := config.GetDefaultConfig()
+		conf.Directories.Templates = "../tests/templates"
+		conf.Directories.Documents = "../tests/sanitize-docs"
+		conf.Sanitize.Mode = test.InputMode
+
+		s, err := Load(&conf, 1, nil)
+		require.NoError(err, test.TestName)
+
+		report := s.Report()
+		assert.Equal(test.ExpectDocuments, report.Documents, test.TestName)
+		assert.Len(report.Failures, test.ExpectFailures, test.TestName)
+		require.Len(report.Violations, 1, test.TestName)
+		assert.Equal("scripts", report.Violations[0].File, test.TestName)
+		assert.Len(report.Violations[0].Violations, 2, test.TestName)
+	}
+}
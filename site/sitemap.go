@@ -0,0 +1,123 @@
+package site
+
+import (
+	"lightsites/constants"
+	"lightsites/document"
+	"lightsites/sitemap"
+
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// sitemapURLs returns the sitemap entries of every public document, in the
+// order of the directory listing. Hidden and draft documents, and documents
+// that opt out of the sitemap, aren't listed.
+func (s *Site) sitemapURLs() []sitemap.URL {
+	urls := []sitemap.URL{}
+	for i := range s.Documents {
+		doc := &s.Documents[i]
+		if document.IsHidden(doc.FileName) || doc.Draft || doc.SitemapExclude {
+			continue
+		}
+		urls = append(urls, sitemap.URL{
+			Loc:        s.documentURL(doc.DocumentName),
+			LastMod:    doc.DateModified,
+			ChangeFreq: doc.SitemapChangeFreq,
+			Priority:   doc.SitemapPriority,
+		})
+	}
+	return urls
+}
+
+// BuildSitemap generates the sitemap, if it is enabled. A site with more
+// documents than a single sitemap may list gets numbered sitemap parts,
+// listed by a sitemap index in place of the sitemap.
+func (s *Site) BuildSitemap() {
+	if !s.Config.Sitemap.Enabled {
+		return
+	}
+	maxURLs := s.Config.Sitemap.MaxURLs
+	if maxURLs <= 0 {
+		maxURLs = constants.SitemapMaxURLs
+	}
+
+	urls := s.sitemapURLs()
+	if len(urls) <= maxURLs {
+		content, err := sitemap.Encode(urls)
+		if err != nil {
+			log.Printf("failed to generate sitemap: %v", err.Error())
+			return
+		}
+		s.Generated[constants.SitemapFile] = newGenerated(constants.SitemapFile, constants.SitemapContentType, content, latestModification(urls, s.LoadedAt))
+		return
+	}
+
+	parts := []sitemap.Part{}
+	generated := []*Generated{}
+	for start := 0; start < len(urls); start += maxURLs {
+		end := start + maxURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+		content, err := sitemap.Encode(urls[start:end])
+		if err != nil {
+			log.Printf("failed to generate sitemap: %v", err.Error())
+			return
+		}
+		partPath := fmt.Sprintf(constants.SitemapPartFile, len(parts)+1)
+		modified := latestModification(urls[start:end], s.LoadedAt)
+		parts = append(parts, sitemap.Part{Loc: s.generatedURL(partPath), LastMod: modified})
+		generated = append(generated, newGenerated(partPath, constants.SitemapContentType, content, modified))
+	}
+
+	content, err := sitemap.EncodeIndex(parts)
+	if err != nil {
+		log.Printf("failed to generate sitemap index: %v", err.Error())
+		return
+	}
+	for _, part := range generated {
+		s.Generated[part.Path] = part
+	}
+	s.Generated[constants.SitemapFile] = newGenerated(constants.SitemapFile, constants.SitemapContentType, content, latestModification(urls, s.LoadedAt))
+}
+
+// latestModification returns the latest modification time of urls, or
+// fallback if none of them has one
+func latestModification(urls []sitemap.URL, fallback time.Time) time.Time {
+	var latest time.Time
+	for _, u := range urls {
+		if u.LastMod.After(latest) {
+			latest = u.LastMod
+		}
+	}
+	if latest.IsZero() {
+		return fallback
+	}
+	return latest
+}
+
+// BuildRobots generates robots.txt, if it is enabled. Hidden documents are
+// disallowed by pattern rather than by name, so that robots.txt doesn't
+// reveal them.
+func (s *Site) BuildRobots() {
+	if !s.Config.Robots.Enabled {
+		return
+	}
+
+	disallow := []string{}
+	if s.Config.Robots.DisallowHidden {
+		prefix := s.Config.Routing.RoutePrefix
+		disallow = append(disallow, prefix+".", prefix+"*/.")
+	}
+	disallow = append(disallow, s.Config.Robots.Disallow...)
+
+	sitemapURL := ""
+	if s.Config.Sitemap.Enabled {
+		sitemapURL = s.generatedURL(constants.SitemapFile)
+	}
+
+	content := sitemap.Robots(disallow, sitemapURL)
+	s.Robots = newGenerated(strings.TrimPrefix(constants.RobotsPath, "/"), constants.RobotsContentType, []byte(content), s.LoadedAt)
+}
@@ -0,0 +1,92 @@
+package site
+
+import (
+	"lightsites/config"
+	"lightsites/constants"
+
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildSitemap validates that the sitemap lists the public documents,
+// and is split into parts listed by a sitemap index when it is too large
+func TestBuildSitemap(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/feed-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.Routing.RoutePrefix = "/"
+	conf.BaseURL = "https://example.com"
+	conf.Sitemap.Enabled = true
+	require.NoError(conf.Validate())
+
+	s, err := Load(&conf, 1, nil)
+	require.NoError(err)
+
+	generated, ok := s.LookupGenerated(constants.SitemapFile)
+	require.True(ok)
+	assert.Equal(constants.SitemapContentType, generated.ContentType)
+	assert.Contains(generated.Content, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	assert.Contains(generated.Content, "<loc>https://example.com/index.html</loc>")
+	assert.Contains(generated.Content, "<loc>https://example.com/blog/first.html</loc>")
+	assert.Contains(generated.Content, "<loc>https://example.com/blog/second.html</loc>\n    <lastmod>")
+	assert.Contains(generated.Content, "<changefreq>weekly</changefreq>\n    <priority>0.8</priority>")
+	assert.Contains(generated.Content, "<lastmod>")
+	// hidden, draft and opted out documents aren't listed
+	assert.NotContains(generated.Content, "hidden")
+	assert.NotContains(generated.Content, "draft")
+	assert.NotContains(generated.Content, "about")
+	assert.Nil(s.Robots)
+
+	conf.Sitemap.MaxURLs = 2
+	s, err = Load(&conf, 1, nil)
+	require.NoError(err)
+
+	var paths []string
+	for _, generated := range s.GeneratedFiles() {
+		paths = append(paths, generated.Path)
+	}
+	assert.Equal([]string{"sitemap-1.xml", "sitemap-2.xml", "sitemap.xml"}, paths)
+	index, ok := s.LookupGenerated(constants.SitemapFile)
+	require.True(ok)
+	assert.Contains(index.Content, `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+	assert.Contains(index.Content, "<loc>https://example.com/sitemap-1.xml</loc>")
+	assert.Contains(index.Content, "<loc>https://example.com/sitemap-2.xml</loc>")
+	first, ok := s.LookupGenerated("sitemap-1.xml")
+	require.True(ok)
+	assert.Contains(first.Content, "<loc>https://example.com/blog/first.html</loc>")
+	assert.Contains(first.Content, "<loc>https://example.com/blog/second.html</loc>")
+	second, ok := s.LookupGenerated("sitemap-2.xml")
+	require.True(ok)
+	assert.Contains(second.Content, "<loc>https://example.com/index.html</loc>")
+}
+
+func TestBuildRobots(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	conf := config.GetDefaultConfig()
+	conf.Directories.Documents = "../tests/feed-docs"
+	conf.Directories.Templates = "../tests/templates"
+	conf.BaseURL = "https://example.com"
+	conf.Sitemap.Enabled = true
+	conf.Robots = config.RobotsConfig{Enabled: true, DisallowHidden: true, Disallow: []string{"/private/"}}
+
+	s, err := Load(&conf, 1, nil)
+	require.NoError(err)
+	require.NotNil(s.Robots)
+	assert.Equal(constants.RobotsContentType, s.Robots.ContentType)
+	assert.Equal("User-agent: *\nDisallow: /content/.\nDisallow: /content/*/.\nDisallow: /private/\n\nSitemap: https://example.com/content/sitemap.xml\n", s.Robots.Content)
+	// hidden documents are disallowed without naming them
+	assert.NotContains(s.Robots.Content, "hidden")
+
+	conf.Sitemap.Enabled = false
+	conf.Robots = config.RobotsConfig{Enabled: true}
+	s, err = Load(&conf, 1, nil)
+	require.NoError(err)
+	assert.Equal("User-agent: *\nDisallow:\n", s.Robots.Content)
+}
@@ -0,0 +1,111 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// URL is a page listed in a sitemap. ChangeFreq and Priority are left out
+// when empty.
+type URL struct {
+	Loc        string
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   string
+}
+
+// Part is a sitemap file listed in a sitemap index
+type Part struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []urlElement `xml:"url"`
+}
+
+type urlElement struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name         `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 sitemapindex"`
+	Sitemaps []sitemapElement `xml:"sitemap"`
+}
+
+type sitemapElement struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// lastMod formats a modification time as a W3C datetime, or returns an
+// empty string if it is unknown
+func lastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Encode returns a sitemap listing urls
+func Encode(urls []URL) ([]byte, error) {
+	set := urlSet{URLs: []urlElement{}}
+	for _, u := range urls {
+		set.URLs = append(set.URLs, urlElement{
+			Loc:        u.Loc,
+			LastMod:    lastMod(u.LastMod),
+			ChangeFreq: u.ChangeFreq,
+			Priority:   u.Priority,
+		})
+	}
+	return encodeXML(set)
+}
+
+// EncodeIndex returns a sitemap index listing the parts of a sitemap that
+// was split into several files
+func EncodeIndex(parts []Part) ([]byte, error) {
+	index := sitemapIndex{Sitemaps: []sitemapElement{}}
+	for _, part := range parts {
+		index.Sitemaps = append(index.Sitemaps, sitemapElement{Loc: part.Loc, LastMod: lastMod(part.LastMod)})
+	}
+	return encodeXML(index)
+}
+
+// encodeXML encodes v as an indented XML document
+func encodeXML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode sitemap: %v", err.Error())
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// Robots returns a robots.txt file that asks every crawler not to visit the
+// disallowed paths, and points them at the sitemap if sitemapURL is set
+func Robots(disallow []string, sitemapURL string) string {
+	var buf strings.Builder
+	buf.WriteString("User-agent: *\n")
+	if len(disallow) == 0 {
+		// an empty Disallow allows everything
+		buf.WriteString("Disallow:\n")
+	}
+	for _, p := range disallow {
+		buf.WriteString(fmt.Sprintf("Disallow: %v\n", p))
+	}
+	if sitemapURL != "" {
+		buf.WriteString(fmt.Sprintf("\nSitemap: %v\n", sitemapURL))
+	}
+	return buf.String()
+}
@@ -0,0 +1,74 @@
+package sitemap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	content, err := Encode([]URL{
+		{Loc: "https://example.com/index.html", LastMod: time.Date(2021, 3, 1, 12, 0, 0, 0, time.FixedZone("CET", 3600))},
+		{Loc: "https://example.com/blog/a&b.html", ChangeFreq: "weekly", Priority: "0.8"},
+	})
+	require.NoError(err)
+	assert.Equal(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url>
+    <loc>https://example.com/index.html</loc>
+    <lastmod>2021-03-01T11:00:00Z</lastmod>
+  </url>
+  <url>
+    <loc>https://example.com/blog/a&amp;b.html</loc>
+    <changefreq>weekly</changefreq>
+    <priority>0.8</priority>
+  </url>
+</urlset>
+`, string(content))
+
+	content, err = EncodeIndex([]Part{
+		{Loc: "https://example.com/sitemap-1.xml", LastMod: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Loc: "https://example.com/sitemap-2.xml"},
+	})
+	require.NoError(err)
+	assert.Equal(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap>
+    <loc>https://example.com/sitemap-1.xml</loc>
+    <lastmod>2021-03-01T00:00:00Z</lastmod>
+  </sitemap>
+  <sitemap>
+    <loc>https://example.com/sitemap-2.xml</loc>
+  </sitemap>
+</sitemapindex>
+`, string(content))
+}
+
+func TestRobots(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		TestName      string
+		InputDisallow []string
+		InputSitemap  string
+		Expected      []string
+	}{
+		{"Robots allow everything", nil, "", []string{"User-agent: *", "Disallow:"}},
+		{
+			"Robots disallow and sitemap",
+			[]string{"/.", "/private/"},
+			"https://example.com/sitemap.xml",
+			[]string{"User-agent: *", "Disallow: /.", "Disallow: /private/", "", "Sitemap: https://example.com/sitemap.xml"},
+		},
+	}
+
+	for _, test := range tests {
+		assert.Equal(strings.Join(test.Expected, "\n")+"\n", Robots(test.InputDisallow, test.InputSitemap), test.TestName)
+	}
+}
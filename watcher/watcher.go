@@ -0,0 +1,227 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Op describes what happened to a file between two scans
+type Op int
+
+const (
+	Created Op = iota
+	Modified
+	Deleted
+)
+
+func (op Op) String() string {
+	switch op {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	}
+	return "unknown"
+}
+
+// DefaultInterval is used when no scan interval is configured
+const DefaultInterval = 2 * time.Second
+
+// Change is a single file that was created, modified or deleted within one
+// of the watched directories
+type Change struct {
+	// Directory is the watched directory, exactly as it was configured
+	Directory string
+	// File is the path of the changed file relative to Directory, using
+	// forward slashes
+	File string
+	Op   Op
+}
+
+// FileState is what is recorded about each file on every scan. The hash is
+// only recomputed when the modification time or size changes, so that
+// unchanged files cost a single stat per scan.
+type FileState struct {
+	ModTime time.Time
+	Size    int64
+	Hash    [sha256.Size]byte
+}
+
+// Snapshot maps each watched directory to the state of every file in it,
+// keyed by the file's slash-separated path relative to the directory
+type Snapshot map[string]map[string]FileState
+
+// Watcher polls a set of directories for changes. It doesn't rely on any
+// operating system notification mechanism, so it works on any filesystem,
+// including network and container volumes.
+type Watcher struct {
+	Directories []string
+	// Interval is how often the directories are scanned
+	Interval time.Duration
+	// Debounce is how long the directories must stay unchanged after a
+	// change is detected before the changes are reported, so that a burst
+	// of writes (such as an editor saving several files) is reported once
+	Debounce time.Duration
+}
+
+// hashFile returns the sha256 hash of a file's contents
+func hashFile(path string) (hash [sha256.Size]byte, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return hash, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return hash, err
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, nil
+}
+
+// Scan records the state of every file in the watched directories. The
+// previous snapshot (which may be nil) is used to avoid re-hashing files
+// whose modification time and size haven't changed.
+func (w *Watcher) Scan(previous Snapshot) (Snapshot, error) {
+	snapshot := make(Snapshot)
+	for _, dir := range w.Directories {
+		files := make(map[string]FileState)
+		err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return fmt.Errorf("err walking path %v: %v", path, err.Error())
+			}
+			if f.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return fmt.Errorf("failed to determine relative path of %v: %v", path, err.Error())
+			}
+			rel = filepath.ToSlash(rel)
+
+			state := FileState{ModTime: f.ModTime(), Size: f.Size()}
+			old, ok := previous[dir][rel]
+			if ok && old.ModTime.Equal(state.ModTime) && old.Size == state.Size {
+				state.Hash = old.Hash
+			} else {
+				state.Hash, err = hashFile(path)
+				if err != nil {
+					return fmt.Errorf("failed to hash %v: %v", path, err.Error())
+				}
+			}
+			files[rel] = state
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan dir %v: %v", dir, err.Error())
+		}
+		snapshot[dir] = files
+	}
+	return snapshot, nil
+}
+
+// Diff returns every file that was created, modified or deleted between
+// two snapshots, sorted by directory and file. A file only counts as
+// modified if its contents changed.
+func Diff(old Snapshot, new Snapshot) []Change {
+	changes := []Change{}
+	for dir, files := range new {
+		for file, state := range files {
+			oldState, ok := old[dir][file]
+			if !ok {
+				changes = append(changes, Change{Directory: dir, File: file, Op: Created})
+			} else if oldState.Hash != state.Hash {
+				changes = append(changes, Change{Directory: dir, File: file, Op: Modified})
+			}
+		}
+	}
+	for dir, files := range old {
+		for file := range files {
+			_, ok := new[dir][file]
+			if !ok {
+				changes = append(changes, Change{Directory: dir, File: file, Op: Deleted})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Directory != changes[j].Directory {
+			return changes[i].Directory < changes[j].Directory
+		}
+		return changes[i].File < changes[j].File
+	})
+
+	return changes
+}
+
+// Watch scans the watched directories every Interval and calls onChange
+// with the accumulated changes once they have settled for Debounce. It
+// blocks until stop is closed. Scan errors (such as a directory being
+// briefly unavailable) are logged and the previous state is kept, so that
+// the next successful scan reports whatever changed in between.
+func (w *Watcher) Watch(stop <-chan struct{}, onChange func([]Change)) {
+	// baseline is the last state that was reported, current is the most
+	// recent scan
+	baseline, err := w.Scan(nil)
+	if err != nil {
+		log.Printf("failed to scan watched directories: %v", err.Error())
+	}
+	current := baseline
+	var lastChange time.Time
+
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		next, err := w.Scan(current)
+		if err != nil {
+			log.Printf("failed to scan watched directories: %v", err.Error())
+			continue
+		}
+		if baseline == nil {
+			// the initial scan failed, so there is nothing to compare to
+			baseline = next
+			current = next
+			continue
+		}
+
+		if len(Diff(current, next)) > 0 {
+			lastChange = time.Now()
+		}
+		current = next
+
+		if lastChange.IsZero() || time.Since(lastChange) < w.Debounce {
+			continue
+		}
+
+		// the directories have settled, so report everything that changed
+		// since the last report. Comparing against the baseline means that
+		// a file that was created and then deleted again isn't reported.
+		lastChange = time.Time{}
+		changes := Diff(baseline, current)
+		baseline = current
+		if len(changes) > 0 {
+			onChange(changes)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanAndDiff(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(os.MkdirAll(filepath.Join(dir, "nested"), 0755))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "b.md"), []byte("b"), 0644))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "nested", "c.md"), []byte("c"), 0644))
+
+	w := Watcher{Directories: []string{dir}}
+
+	before, err := w.Scan(nil)
+	require.NoError(err)
+	assert.Len(before[dir], 3)
+
+	// rewriting a file with identical contents is not a modification, even
+	// though its modification time changes
+	later := time.Now().Add(time.Minute)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644))
+	require.NoError(os.Chtimes(filepath.Join(dir, "a.md"), later, later))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "b.md"), []byte("bb"), 0644))
+	require.NoError(os.Remove(filepath.Join(dir, "nested", "c.md")))
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "nested", "d.md"), []byte("d"), 0644))
+
+	after, err := w.Scan(before)
+	require.NoError(err)
+
+	assert.Equal([]Change{
+		{Directory: dir, File: "b.md", Op: Modified},
+		{Directory: dir, File: "nested/c.md", Op: Deleted},
+		{Directory: dir, File: "nested/d.md", Op: Created},
+	}, Diff(before, after))
+	assert.Equal([]Change{}, Diff(after, after))
+
+	w.Directories = []string{filepath.Join(dir, "does-not-exist")}
+	_, err = w.Scan(nil)
+	assert.Error(err)
+}
+
+// TestWatch validates that a burst of changes is reported once, after the
+// directory has settled
+func TestWatch(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := t.TempDir()
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a.md"), []byte("a"), 0644))
+
+	w := Watcher{
+		Directories: []string{dir},
+		Interval:    10 * time.Millisecond,
+		Debounce:    50 * time.Millisecond,
+	}
+
+	stop := make(chan struct{})
+	reported := make(chan []Change, 10)
+	done := make(chan struct{})
+	go func() {
+		w.Watch(stop, func(changes []Change) { reported <- changes })
+		close(done)
+	}()
+
+	// give the watcher time to take its initial scan
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "b.md"), []byte("b"), 0644))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(ioutil.WriteFile(filepath.Join(dir, "a.md"), []byte("aa"), 0644))
+
+	select {
+	case changes := <-reported:
+		assert.Equal([]Change{
+			{Directory: dir, File: "a.md", Op: Modified},
+			{Directory: dir, File: "b.md", Op: Created},
+		}, changes)
+	case <-time.After(2 * time.Second):
+		t.Error("watcher did not report changes")
+	}
+
+	close(stop)
+	<-done
+	assert.Len(reported, 0)
+}